@@ -0,0 +1,372 @@
+package pairtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/tidwall/pair"
+)
+
+// A delta records the nodes of a tree that are new since the previous
+// checkpoint, and the ids of the nodes that are no longer part of the tree:
+//
+//	magic    "PTRD"
+//	version  1 byte
+//	degree   uvarint
+//	sequence uvarint, zero for the base and incremented by every delta
+//	count    uvarint, number of pairs in the tree
+//	root     uvarint, id of the root node, or zero for an empty tree
+//	dropped  uvarint count, then the uvarint id of each dropped node
+//	nodes    uvarint count, then each node in post-order:
+//	         uvarint id
+//	         uvarint item count, then each length-prefixed key and value
+//	         uvarint child count, then the uvarint id of each child
+//	checksum 4 bytes, little endian CRC-32C of everything before it
+//
+// A child id refers to a node earlier in the same delta, or to a node of
+// the tree as of the previous checkpoint.
+const (
+	deltaMagic   = "PTRD"
+	deltaVersion = 1
+)
+
+// ErrDeltaSequence is returned when applying a delta that does not follow
+// the last one applied.
+var ErrDeltaSequence = errors.New("pairtree: delta out of sequence")
+
+// Checkpointer writes a chain of checkpoints of a tree: a base holding every
+// node of the tree, followed by deltas that only hold the nodes that are new
+// since the previous checkpoint.
+//
+// Because Clone shares every node that is not modified afterwards, a tree
+// that has seen a few writes since the previous checkpoint differs from it
+// only on the paths from the root to the modified items.  A delta is
+// therefore O(w log n) in size for w writes, whatever the size of the tree.
+//
+// A Checkpointer tags the nodes it writes, so a tree, along with its clones,
+// must only be checkpointed by one Checkpointer.  The tree may be replaced by
+// a clone of itself from before an earlier checkpoint, to roll back writes,
+// and the nodes it holds that have left the tree since are written afresh.
+type Checkpointer struct {
+	prev   *PairTree // clone of the tree as of the previous checkpoint
+	nextID uint64
+	seq    uint64
+}
+
+// NewCheckpointer returns a Checkpointer whose first checkpoint is a base.
+func NewCheckpointer() *Checkpointer {
+	return &Checkpointer{nextID: 1}
+}
+
+// Checkpoint writes the changes to tr since the previous checkpoint to w,
+// returning the number of bytes written.  The first checkpoint is a base
+// holding the whole tree.  If the checkpoint is not written in full, the
+// next checkpoint holds its changes too.
+//
+// Checkpoint clones tr, so it must not be called concurrently with writes to
+// tr.
+func (c *Checkpointer) Checkpoint(w io.Writer, tr *PairTree) (int64, error) {
+	frozen := tr.Clone()
+	if c.prev != nil && c.prev.degree != frozen.degree {
+		return 0, errors.New("pairtree: tree degree changed between checkpoints")
+	}
+	// Collect the new nodes, and the nodes of the previous checkpoint that
+	// they refer to.
+	var fresh []*node
+	kept := make(map[*node]bool)
+	if frozen.root != nil {
+		if frozen.root.id != 0 {
+			kept[frozen.root] = true
+		} else {
+			fresh = collectFresh(frozen.root, fresh, kept)
+		}
+	}
+	nextID := c.nextID
+	for _, n := range fresh {
+		n.id = nextID
+		nextID++
+	}
+	dropped, err := c.dropped(kept)
+	if err == nil {
+		var n int64
+		n, err = c.writeDelta(w, frozen, dropped, fresh)
+		if err == nil {
+			// A dropped node may still be shared with a clone, such as one
+			// the tree is rolled back to, and is written afresh should it
+			// return to the tree.
+			for _, d := range dropped {
+				d.id = 0
+			}
+			c.prev, c.nextID = frozen, nextID
+			c.seq++
+			return n, nil
+		}
+	}
+	for _, n := range fresh {
+		n.id = 0
+	}
+	return 0, err
+}
+
+// collectFresh appends the nodes of the subtree rooted at n that are not part
+// of the previous checkpoint, in post-order, recording the written nodes
+// that they refer to in kept.
+func collectFresh(n *node, fresh []*node, kept map[*node]bool) []*node {
+	for _, child := range n.children {
+		if child.id != 0 {
+			kept[child] = true
+		} else {
+			fresh = collectFresh(child, fresh, kept)
+		}
+	}
+	return append(fresh, n)
+}
+
+// dropped returns the nodes of the previous checkpoint that are no longer in
+// the tree, given the ones that are still referred to.  Since the
+// nodes of a checkpoint are never modified, all of the nodes beneath a kept
+// node are kept too, so only the nodes above the kept ones are visited.
+func (c *Checkpointer) dropped(kept map[*node]bool) ([]*node, error) {
+	var dropped []*node
+	found := 0
+	var visit func(n *node)
+	visit = func(n *node) {
+		if kept[n] {
+			found++
+			return
+		}
+		dropped = append(dropped, n)
+		for _, child := range n.children {
+			visit(child)
+		}
+	}
+	if c.prev != nil && c.prev.root != nil {
+		visit(c.prev.root)
+	}
+	if found != len(kept) {
+		return nil, errors.New("pairtree: tree was checkpointed by another Checkpointer")
+	}
+	return dropped, nil
+}
+
+func (c *Checkpointer) writeDelta(w io.Writer, tr *PairTree, dropped, fresh []*node) (int64, error) {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.New(castagnoli)}
+	sw.write([]byte(deltaMagic))
+	sw.write([]byte{deltaVersion})
+	sw.uvarint(uint64(tr.degree))
+	sw.uvarint(c.seq)
+	sw.uvarint(uint64(tr.length))
+	if tr.root != nil {
+		sw.uvarint(tr.root.id)
+	} else {
+		sw.uvarint(0)
+	}
+	sw.uvarint(uint64(len(dropped)))
+	for _, n := range dropped {
+		sw.uvarint(n.id)
+	}
+	sw.uvarint(uint64(len(fresh)))
+	for _, n := range fresh {
+		sw.uvarint(n.id)
+		sw.uvarint(uint64(len(n.items)))
+		for _, item := range n.items {
+			sw.bytes(item.Key())
+			sw.bytes(item.Value())
+		}
+		sw.uvarint(uint64(len(n.children)))
+		for _, child := range n.children {
+			sw.uvarint(child.id)
+		}
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], sw.crc.Sum32())
+	sw.write(sum[:])
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.n, sw.err
+}
+
+// DeltaLoader rebuilds a tree from a chain of checkpoints written by a
+// Checkpointer.  Applying a delta costs time in proportion to its size, not
+// to the size of the tree.
+type DeltaLoader struct {
+	tr    *PairTree
+	nodes map[uint64]*node
+	seq   uint64 // sequence of the next delta
+}
+
+// NewDeltaLoader returns a DeltaLoader for a tree with the ordering and free
+// list given by opts.  The degree of the tree is taken from the base.
+func NewDeltaLoader(opts Options) *DeltaLoader {
+	return &DeltaLoader{tr: NewWithOptions(opts), nodes: make(map[uint64]*node)}
+}
+
+// Apply reads a checkpoint from r and applies it to the tree, returning the
+// number of bytes read.  The first checkpoint applied must be the base, and
+// each one after must be the next in the chain.
+//
+// ErrCorrupt is returned if the checkpoint is malformed or fails its
+// checksum, and ErrDeltaSequence if it is not the next in the chain.  On
+// error the tree is left untouched.
+//
+// Apply reads no further than the end of the checkpoint, so a chain of
+// checkpoints can be applied one after another from a single reader.  When
+// r is an io.ByteReader, such as a bufio.Reader, it is read from directly.
+// Otherwise it is read without buffering, which costs a read for each byte
+// of the lengths and ids in the checkpoint, so to apply a chain from a slow
+// reader, wrap it in a bufio.Reader and apply every checkpoint from that.
+func (l *DeltaLoader) Apply(r io.Reader) (int64, error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = &unbufferedReader{Reader: r}
+	}
+	sr := &snapshotReader{r: br, crc: crc32.New(castagnoli)}
+	err := l.readDelta(sr)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return sr.n, err
+}
+
+func (l *DeltaLoader) readDelta(sr *snapshotReader) error {
+	var header [len(deltaMagic) + 1]byte
+	if err := sr.readFull(header[:]); err != nil {
+		return err
+	}
+	if string(header[:len(deltaMagic)]) != deltaMagic {
+		return ErrCorrupt
+	}
+	if header[len(deltaMagic)] != deltaVersion {
+		return fmt.Errorf("pairtree: unsupported delta version %d", header[len(deltaMagic)])
+	}
+	var fields [5]uint64 // degree, sequence, count, root and dropped count
+	for i := range fields {
+		x, err := sr.uvarint()
+		if err != nil {
+			return err
+		}
+		fields[i] = x
+	}
+	degree, seq, count, rootID, ndropped := fields[0], fields[1], fields[2], fields[3], fields[4]
+	if seq != l.seq || (seq > 0 && int(degree) != l.tr.degree) {
+		return ErrDeltaSequence
+	}
+	if degree < 2 || degree > maxSnapshotLength || count > maxSnapshotLength ||
+		ndropped > uint64(len(l.nodes)) {
+		return ErrCorrupt
+	}
+	dropped := make([]uint64, ndropped)
+	for i := range dropped {
+		id, err := sr.uvarint()
+		if err != nil {
+			return err
+		}
+		if l.nodes[id] == nil {
+			return ErrCorrupt
+		}
+		dropped[i] = id
+	}
+	nfresh, err := sr.uvarint()
+	if err != nil {
+		return err
+	}
+	if nfresh > maxSnapshotLength {
+		return ErrCorrupt
+	}
+	fresh := make(map[uint64]*node)
+	lookup := func(id uint64) *node {
+		if n := fresh[id]; n != nil {
+			return n
+		}
+		return l.nodes[id]
+	}
+	maxPairs := uint64(2*degree - 1)
+	for i := uint64(0); i < nfresh; i++ {
+		var fields [2]uint64 // id and item count
+		for j := range fields {
+			if fields[j], err = sr.uvarint(); err != nil {
+				return err
+			}
+		}
+		id, nitems := fields[0], fields[1]
+		if id == 0 || lookup(id) != nil || nitems > maxPairs {
+			return ErrCorrupt
+		}
+		// The node is not tagged with its id, which belongs to the chain
+		// being loaded, so the tree can be checkpointed afresh.
+		n := &node{}
+		for j := uint64(0); j < nitems; j++ {
+			key, err := sr.bytes(nil)
+			if err != nil {
+				return err
+			}
+			value, err := sr.bytes(nil)
+			if err != nil {
+				return err
+			}
+			n.items = append(n.items, pair.New(key, value))
+		}
+		n.count = len(n.items)
+		nchildren, err := sr.uvarint()
+		if err != nil {
+			return err
+		}
+		if nchildren != 0 && nchildren != nitems+1 {
+			return ErrCorrupt
+		}
+		if nchildren > 0 {
+			n.children = make(children, nchildren)
+		}
+		for j := range n.children {
+			childID, err := sr.uvarint()
+			if err != nil {
+				return err
+			}
+			child := lookup(childID)
+			if child == nil {
+				return ErrCorrupt
+			}
+			n.children[j] = child
+			n.count += child.count
+		}
+		fresh[id] = n
+	}
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if err := sr.readFull(sum[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != want {
+		return ErrCorrupt
+	}
+	var root *node
+	if rootID != 0 {
+		if root = lookup(rootID); root == nil || uint64(root.count) != count {
+			return ErrCorrupt
+		}
+	} else if count != 0 {
+		return ErrCorrupt
+	}
+	for _, id := range dropped {
+		delete(l.nodes, id)
+	}
+	for id, n := range fresh {
+		l.nodes[id] = n
+	}
+	l.tr.degree = int(degree)
+	l.tr.root, l.tr.length = root, int(count)
+	l.tr.mods++
+	l.seq++
+	return nil
+}
+
+// Tree returns a clone of the tree as of the last checkpoint applied.  See
+// PairTree.Clone.
+func (l *DeltaLoader) Tree() *PairTree {
+	return l.tr.Clone()
+}
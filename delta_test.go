@@ -0,0 +1,199 @@
+package pairtree
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestDelta(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 3, Less: lessFn})
+	for _, v := range perm(2000) {
+		tr.ReplaceOrInsert(v)
+	}
+	c := NewCheckpointer()
+	l := NewDeltaLoader(Options{Less: lessFn})
+	var base bytes.Buffer
+	if _, err := c.Checkpoint(&base, tr); err != nil {
+		t.Fatal(err)
+	}
+	var deltas [][]byte
+	deltas = append(deltas, base.Bytes())
+	if _, err := l.Apply(bytes.NewReader(base.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 5; i++ {
+			if v := rng.Intn(3000); rng.Intn(2) == 0 {
+				tr.ReplaceOrInsert(Int(v))
+			} else {
+				tr.Delete(Int(v))
+			}
+		}
+		if round == 20 {
+			// A failed checkpoint leaves its changes to the next one.
+			if _, err := c.Checkpoint(failWriter{}, tr); err == nil {
+				t.Fatal("expected an error")
+			}
+			tr.DeleteMin()
+		}
+		if round == 30 {
+			// Large changes, which merge and split nodes.
+			tr.DeleteRange(Int(100), Int(1500))
+		}
+		var buf bytes.Buffer
+		if _, err := c.Checkpoint(&buf, tr); err != nil {
+			t.Fatal(err)
+		}
+		if round != 30 && buf.Len() > base.Len()/10 {
+			t.Fatalf("round %d: delta of %d bytes for a base of %d", round, buf.Len(), base.Len())
+		}
+		deltas = append(deltas, buf.Bytes())
+		if _, err := l.Apply(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		out := l.Tree()
+		checkTree(t, out)
+		if got, want := treeInts(out), treeInts(tr); !intsEqual(got, want) {
+			t.Fatalf("round %d: expected %v, got %v", round, want, got)
+		}
+		// Writing to the loaded tree does not change the loader.
+		out.DeleteRange(nilPair, nilPair)
+	}
+
+	// The whole chain loads from scratch, in order only.
+	l = NewDeltaLoader(Options{Less: lessFn})
+	if _, err := l.Apply(bytes.NewReader(deltas[1])); err != ErrDeltaSequence {
+		t.Fatalf("expected ErrDeltaSequence, got %v", err)
+	}
+	for i, delta := range deltas {
+		bad := append([]byte(nil), delta...)
+		bad[len(bad)/2] ^= 1
+		if _, err := l.Apply(bytes.NewReader(bad)); err == nil {
+			t.Fatalf("delta %d: expected an error for a corrupt delta", i)
+		}
+		if _, err := l.Apply(bytes.NewReader(delta)); err != nil {
+			t.Fatalf("delta %d: %v", i, err)
+		}
+	}
+	if got, want := treeInts(l.Tree()), treeInts(tr); !intsEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	// Dropped nodes are forgotten, so the loader only holds the tree's nodes.
+	if n := countNodes(l.tr.root); len(l.nodes) != n {
+		t.Fatalf("loader holds %d nodes for a tree of %d", len(l.nodes), n)
+	}
+
+	// The loaded tree can start a chain of its own, but the original tree
+	// cannot be checkpointed by another Checkpointer.
+	var buf bytes.Buffer
+	if _, err := NewCheckpointer().Checkpoint(&buf, l.Tree()); err != nil {
+		t.Fatal(err)
+	}
+	c2 := NewCheckpointer()
+	c2.Checkpoint(&buf, New(lessFn))
+	if _, err := c2.Checkpoint(&buf, tr); err == nil {
+		t.Fatal("expected an error checkpointing with another Checkpointer")
+	}
+}
+
+func TestDeltaRollback(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 2, Less: lessFn})
+	for _, v := range perm(200) {
+		tr.ReplaceOrInsert(v)
+	}
+	c := NewCheckpointer()
+	l := NewDeltaLoader(Options{Less: lessFn})
+	checkpoint := func(round int) {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := c.Checkpoint(&buf, tr); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if _, err := l.Apply(&buf); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if got, want := treeInts(l.Tree()), treeInts(tr); !intsEqual(got, want) {
+			t.Fatalf("round %d: expected %v, got %v", round, want, got)
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		saved := tr.Clone()
+		checkpoint(round)
+		for i := 0; i < 20; i++ {
+			tr.Delete(Int(rng.Intn(300)))
+			tr.ReplaceOrInsert(Int(rng.Intn(300)))
+		}
+		checkpoint(round)
+		// Roll back, sometimes with writes on top.
+		tr = saved
+		if round%2 == 1 {
+			tr.DeleteRange(Int(rng.Intn(300)), Int(rng.Intn(300)))
+		}
+		checkpoint(round)
+	}
+}
+
+func TestDeltaStream(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 3, Less: lessFn})
+	c := NewCheckpointer()
+	var stream bytes.Buffer
+	var want [][]int
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 50; i++ {
+			tr.ReplaceOrInsert(Int(round*50 + i))
+		}
+		tr.Delete(Int(round * 7))
+		if _, err := c.Checkpoint(&stream, tr); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, treeInts(tr))
+	}
+	// The whole chain is applied from one reader, buffered or not.
+	readers := []io.Reader{
+		struct{ io.Reader }{bytes.NewReader(stream.Bytes())},
+		bufio.NewReader(bytes.NewReader(stream.Bytes())),
+	}
+	for _, r := range readers {
+		l := NewDeltaLoader(Options{Less: lessFn})
+		var total int64
+		for round := range want {
+			n, err := l.Apply(r)
+			if err != nil {
+				t.Fatalf("round %d: %v", round, err)
+			}
+			total += n
+			if got := treeInts(l.Tree()); !intsEqual(got, want[round]) {
+				t.Fatalf("round %d: expected %v, got %v", round, want[round], got)
+			}
+		}
+		if total != int64(stream.Len()) {
+			t.Fatalf("read %d bytes of a %d byte stream", total, stream.Len())
+		}
+		if _, err := l.Apply(r); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected unexpected EOF at the end of the stream, got %v", err)
+		}
+	}
+}
+
+func countNodes(n *node) int {
+	if n == nil {
+		return 0
+	}
+	count := 1
+	for _, child := range n.children {
+		count += countNodes(child)
+	}
+	return count
+}
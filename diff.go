@@ -0,0 +1,127 @@
+package pairtree
+
+import (
+	"bytes"
+
+	"github.com/tidwall/pair"
+)
+
+// DiffKind describes how an item differs between two trees.
+type DiffKind int
+
+const (
+	DiffInserted DiffKind = iota // the item is only in the new tree
+	DiffDeleted                  // the item is only in the old tree
+	DiffChanged                  // the item is in both trees with different values
+)
+
+// diffEntry is either a pending subtree or a single pending item.
+type diffEntry struct {
+	n      *node
+	item   pair.Pair
+	height int
+}
+
+// diffStack holds the pending entries of one side of a Diff, with the
+// smallest entry on top.
+type diffStack []diffEntry
+
+func (s *diffStack) push(e diffEntry) {
+	*s = append(*s, e)
+}
+
+func (s *diffStack) pop() diffEntry {
+	e := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return e
+}
+
+// expand replaces the subtree on top of the stack with its items and
+// children.
+func (s *diffStack) expand() {
+	e := s.pop()
+	n := e.n
+	for i := len(n.items); i >= 0; i-- {
+		if len(n.children) > 0 {
+			s.push(diffEntry{n: n.children[i], height: e.height - 1})
+		}
+		if i > 0 {
+			s.push(diffEntry{item: n.items[i-1]})
+		}
+	}
+}
+
+// Diff calls fn for every item that differs between the trees a and b, in
+// ascending order, until fn returns false.  Items only in b are reported as
+// DiffInserted with a nil old item, items only in a are reported as
+// DiffDeleted with a nil new item, and equivalent items with different values
+// are reported as DiffChanged.  Items are ordered by the less function of a.
+//
+// Subtrees that are shared by both trees, such as those left untouched since
+// one tree was cloned from the other, are skipped without being visited, so
+// diffing two clones runs in time proportional to the number of changes
+// between them rather than to the size of the trees.
+func Diff(a, b *PairTree, fn func(kind DiffKind, old, new pair.Pair) bool) {
+	var sa, sb diffStack
+	if a.root != nil && a.root.count > 0 {
+		sa.push(diffEntry{n: a.root, height: a.root.height()})
+	}
+	if b.root != nil && b.root.count > 0 {
+		sb.push(diffEntry{n: b.root, height: b.root.height()})
+	}
+	for len(sa) > 0 || len(sb) > 0 {
+		switch {
+		case len(sa) == 0:
+			if sb[len(sb)-1].n != nil {
+				sb.expand()
+			} else if !fn(DiffInserted, nilPair, sb.pop().item) {
+				return
+			}
+			continue
+		case len(sb) == 0:
+			if sa[len(sa)-1].n != nil {
+				sa.expand()
+			} else if !fn(DiffDeleted, sa.pop().item, nilPair) {
+				return
+			}
+			continue
+		}
+		ea, eb := sa[len(sa)-1], sb[len(sb)-1]
+		switch {
+		case ea.n != nil && eb.n != nil:
+			if ea.n == eb.n {
+				sa.pop()
+				sb.pop()
+				continue
+			}
+			// Expand the taller subtree first, giving the shorter one a
+			// chance to line up with an identical node on the other side.
+			if ea.height >= eb.height {
+				sa.expand()
+			}
+			if eb.height >= ea.height {
+				sb.expand()
+			}
+		case ea.n != nil:
+			sa.expand()
+		case eb.n != nil:
+			sb.expand()
+		case a.less(ea.item, eb.item):
+			if !fn(DiffDeleted, sa.pop().item, nilPair) {
+				return
+			}
+		case a.less(eb.item, ea.item):
+			if !fn(DiffInserted, nilPair, sb.pop().item) {
+				return
+			}
+		default:
+			sa.pop()
+			sb.pop()
+			if ea.item != eb.item && !bytes.Equal(ea.item.Value(), eb.item.Value()) {
+				if !fn(DiffChanged, ea.item, eb.item) {
+					return
+				}
+			}
+		}
+	}
+}
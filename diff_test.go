@@ -0,0 +1,107 @@
+package pairtree
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+// intVal returns an Int item with a value.
+func intVal(i int, val string) pair.Pair {
+	return pair.New(Int(i).Key(), []byte(val))
+}
+
+func diffString(a, b *PairTree) string {
+	var out []string
+	Diff(a, b, func(kind DiffKind, old, new pair.Pair) bool {
+		switch kind {
+		case DiffInserted:
+			out = append(out, fmt.Sprintf("+%d", PairInt(new)))
+		case DiffDeleted:
+			out = append(out, fmt.Sprintf("-%d", PairInt(old)))
+		case DiffChanged:
+			out = append(out, fmt.Sprintf("~%d:%s:%s", PairInt(old), old.Value(), new.Value()))
+		}
+		return true
+	})
+	return strings.Join(out, ",")
+}
+
+func TestDiff(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		n := rand.Intn(1000)
+		a := NewWithOptions(Options{Degree: 2 + rand.Intn(4), Less: lessFn})
+		for _, v := range rand.Perm(n) {
+			a.ReplaceOrInsert(intVal(v, "a"))
+		}
+		b := a.Clone()
+		for j := 0; j < rand.Intn(20); j++ {
+			v := rand.Intn(n + 10)
+			switch rand.Intn(3) {
+			case 0:
+				b.Delete(Int(v))
+			case 1:
+				b.ReplaceOrInsert(intVal(v, "b"))
+			case 2:
+				// Replacing with an equal value is not a change.
+				b.ReplaceOrInsert(intVal(v, "a"))
+			}
+		}
+		var want []string
+		for v := 0; v < n+10; v++ {
+			old, new := a.Get(Int(v)), b.Get(Int(v))
+			switch {
+			case old == nilPair && new != nilPair:
+				want = append(want, fmt.Sprintf("+%d", v))
+			case old != nilPair && new == nilPair:
+				want = append(want, fmt.Sprintf("-%d", v))
+			case old != nilPair && string(old.Value()) != string(new.Value()):
+				want = append(want, fmt.Sprintf("~%d:%s:%s", v, old.Value(), new.Value()))
+			}
+		}
+		if got := diffString(a, b); got != strings.Join(want, ",") {
+			t.Fatalf("mismatch:\n got: %v\nwant: %v", got, strings.Join(want, ","))
+		}
+	}
+}
+
+func TestDiffUnrelated(t *testing.T) {
+	a, b := intsTree(1, 2, 3, 5), intsTree(2, 4, 5, 6)
+	if got, want := diffString(a, b), "-1,-3,+4,+6"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got, want := diffString(intsTree(), b), "+2,+4,+5,+6"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := diffString(a, a); got != "" {
+		t.Fatalf("expected no differences, got %q", got)
+	}
+	var count int
+	Diff(a, b, func(kind DiffKind, old, new pair.Pair) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Fatalf("expected diff to stop after 2 items, got %d", count)
+	}
+}
+
+func BenchmarkDiffClone(b *testing.B) {
+	tr := New(lessFn)
+	if err := tr.BulkLoad(rang(benchmarkTreeSize * 10)); err != nil {
+		b.Fatal(err)
+	}
+	clone := tr.Clone()
+	for i := 0; i < 10; i++ {
+		clone.ReplaceOrInsert(intVal(rand.Intn(benchmarkTreeSize*10), "x"))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Diff(tr, clone, func(kind DiffKind, old, new pair.Pair) bool {
+			return true
+		})
+	}
+}
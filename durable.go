@@ -0,0 +1,550 @@
+package pairtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tidwall/pair"
+)
+
+// SyncPolicy controls when a DurableTree flushes its log to stable storage.
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // fsync after every write
+	SyncInterval                   // fsync in the background, once per interval
+	SyncNever                      // leave flushing to the operating system
+)
+
+// DurableOptions are used to configure a DurableTree opened by OpenDurable.
+type DurableOptions struct {
+	// Options configure the in-memory tree.  The ordering must be the same
+	// every time the tree is opened, but the degree may change, in which
+	// case a snapshot of another degree is rebuilt when it is loaded.
+	Options
+	// Sync is the policy for flushing the log to stable storage.  Writes
+	// that were not flushed may be lost in a crash.
+	Sync SyncPolicy
+	// SyncInterval is the time between flushes for the SyncInterval policy.
+	// Zero uses the default of one second, and a negative interval is an
+	// error.
+	SyncInterval time.Duration
+	// CompactSize is the size in bytes that the log may grow to before the
+	// tree is automatically compacted.  Zero disables automatic compaction.
+	// Should an automatic compaction fail, the write that started it still
+	// succeeds, and compaction is tried again once the log has grown by
+	// another CompactSize.  Compact reports the error, if it persists.
+	CompactSize int64
+}
+
+// The names of the files a DurableTree keeps in its directory.
+const (
+	durableSnapshot = "snapshot"
+	durableLog      = "wal"
+)
+
+// The operations of log records.
+const (
+	opSet    byte = 1
+	opDelete byte = 2
+)
+
+// logHeaderSize is the size of the header of a log record: the length of the
+// payload and the CRC-32C of the payload, both 4 bytes little endian.  The
+// payload is the operation, followed by the length-prefixed key, followed by
+// the length-prefixed value for opSet.
+const logHeaderSize = 8
+
+// DurableTree is a PairTree that survives crashes and restarts.  Every write
+// is appended to a log file before it is applied, and the log is replayed
+// when the tree is opened.  Compact writes a snapshot of the tree and empties
+// the log, so that the log does not grow forever.
+//
+// DurableTree is safe for concurrent use by multiple goroutines.
+type DurableTree struct {
+	mu        sync.RWMutex
+	tr        *PairTree
+	dir       string
+	log       *os.File
+	size      int64 // size of the log
+	opts      DurableOptions
+	dirty     bool  // log has writes that have not been flushed
+	err       error // first error writing the log, after which writes fail
+	compactAt int64 // size of the log at which it is automatically compacted
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// ErrClosed is returned when writing to a DurableTree, or closing a
+// MappedTree, that has already been closed.
+var ErrClosed = errors.New("pairtree: tree is closed")
+
+// OpenDurable opens the durable tree stored in dir, creating dir if needed.
+// The tree is loaded from the snapshot in dir, if any, and the log is then
+// replayed on top of it.
+//
+// A crash can leave a partially written record at the end of the log.  The
+// log is truncated at the first record that is incomplete or fails its
+// checksum, and every record before it is kept.
+func OpenDurable(dir string, opts DurableOptions) (*DurableTree, error) {
+	if opts.SyncInterval < 0 {
+		return nil, errors.New("pairtree: negative SyncInterval")
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	tr := NewWithOptions(opts.Options)
+	if f, err := os.Open(filepath.Join(dir, durableSnapshot)); err == nil {
+		degree := tr.degree
+		_, err = tr.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if tr.degree != degree {
+			// ReadFrom takes on the degree of the snapshot, so rebuild
+			// the tree with the degree given by opts.
+			sorted := make([]pair.Pair, 0, tr.Len())
+			tr.Ascend(func(item pair.Pair) bool {
+				sorted = append(sorted, item)
+				return true
+			})
+			tr.degree = degree
+			tr.load(sorted)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, durableLog), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := replayLog(tr, log)
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	t := &DurableTree{tr: tr, dir: dir, log: log, size: size, opts: opts,
+		compactAt: opts.CompactSize}
+	if opts.Sync == SyncInterval {
+		t.stop, t.done = make(chan struct{}), make(chan struct{})
+		go t.syncLoop(t.stop)
+	}
+	return t, nil
+}
+
+// replayLog applies the records of the log to tr, truncating the log after
+// the last valid record and leaving the file positioned at its end.  It
+// returns the size of the log.
+func replayLog(tr *PairTree, log *os.File) (int64, error) {
+	data, err := readAll(log)
+	if err != nil {
+		return 0, err
+	}
+	var off int
+	for len(data)-off >= logHeaderSize {
+		n := int(binary.LittleEndian.Uint32(data[off:]))
+		sum := binary.LittleEndian.Uint32(data[off+4:])
+		if n > len(data)-off-logHeaderSize {
+			break
+		}
+		payload := data[off+logHeaderSize : off+logHeaderSize+n]
+		if crc32.Checksum(payload, castagnoli) != sum || !applyRecord(tr, payload) {
+			break
+		}
+		off += logHeaderSize + n
+	}
+	if off < len(data) {
+		if err := log.Truncate(int64(off)); err != nil {
+			return 0, err
+		}
+		if err := log.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := log.Seek(int64(off), 0); err != nil {
+		return 0, err
+	}
+	return int64(off), nil
+}
+
+func readAll(f *os.File) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// applyRecord applies the payload of a log record to tr, returning false if
+// the payload is malformed.
+func applyRecord(tr *PairTree, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	op, rest := payload[0], payload[1:]
+	key, rest, ok := readLogBytes(rest)
+	if !ok {
+		return false
+	}
+	switch op {
+	case opSet:
+		value, rest, ok := readLogBytes(rest)
+		if !ok || len(rest) != 0 {
+			return false
+		}
+		tr.ReplaceOrInsert(pair.New(key, value))
+	case opDelete:
+		if len(rest) != 0 {
+			return false
+		}
+		tr.Delete(pair.New(key, nil))
+	default:
+		return false
+	}
+	return true
+}
+
+func readLogBytes(p []byte) (b, rest []byte, ok bool) {
+	n, size := binary.Uvarint(p)
+	if size <= 0 || n > uint64(len(p)-size) {
+		return nil, nil, false
+	}
+	return p[size : size+int(n)], p[size+int(n):], true
+}
+
+// syncDir flushes the directory entries of dir, making newly created and
+// renamed files durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	d.Close()
+	return err
+}
+
+// syncLoop flushes the log once per interval for the SyncInterval policy,
+// until stop is closed.
+func (t *DurableTree) syncLoop(stop <-chan struct{}) {
+	defer close(t.done)
+	ticker := time.NewTicker(t.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			if t.dirty && t.err == nil && t.log != nil {
+				if err := t.log.Sync(); err != nil {
+					t.err = err
+				}
+				t.dirty = false
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// append writes a record to the log, flushing it as the sync policy
+// requires.  The caller must hold the write lock.
+func (t *DurableTree) append(op byte, key, value []byte) error {
+	if t.log == nil {
+		return ErrClosed
+	}
+	if t.err != nil {
+		return t.err
+	}
+	rec := make([]byte, logHeaderSize, logHeaderSize+1+2*binary.MaxVarintLen64+len(key)+len(value))
+	rec = append(rec, op)
+	rec = binary.AppendUvarint(rec, uint64(len(key)))
+	rec = append(rec, key...)
+	if op == opSet {
+		rec = binary.AppendUvarint(rec, uint64(len(value)))
+		rec = append(rec, value...)
+	}
+	payload := rec[logHeaderSize:]
+	binary.LittleEndian.PutUint32(rec, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(payload, castagnoli))
+	if _, err := t.log.Write(rec); err != nil {
+		// The log may now end with a partial record, which would be
+		// truncated on replay, but any records after it would be lost too.
+		t.err = err
+		return err
+	}
+	t.size += int64(len(rec))
+	switch t.opts.Sync {
+	case SyncAlways:
+		if err := t.log.Sync(); err != nil {
+			t.err = err
+			return err
+		}
+	case SyncInterval:
+		t.dirty = true
+	}
+	return nil
+}
+
+// maybeCompact compacts the tree if the log has outgrown CompactSize.  A
+// failure is not returned, since the write before it was applied, and the
+// log still holds it.  Should the log itself fail, writes fail with t.err.
+// The caller must hold the write lock.
+func (t *DurableTree) maybeCompact() {
+	if t.opts.CompactSize > 0 && t.size >= t.compactAt {
+		if t.compact() != nil {
+			t.compactAt = t.size + t.opts.CompactSize
+		}
+	}
+}
+
+// ReplaceOrInsert adds the given item to the tree, returning the item it
+// replaced, if any.  See PairTree.ReplaceOrInsert.  If the write cannot be
+// logged, the tree is not changed and the error is returned.
+func (t *DurableTree) ReplaceOrInsert(item pair.Pair) (pair.Pair, error) {
+	if item == nilPair {
+		panic("nil item being added to BTree")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.append(opSet, item.Key(), item.Value()); err != nil {
+		return nilPair, err
+	}
+	prev := t.tr.ReplaceOrInsert(item)
+	t.maybeCompact()
+	return prev, nil
+}
+
+// Delete removes an item equal to the passed in item from the tree, returning
+// it.  If no such item exists, returns nil.  If the write cannot be logged,
+// the tree is not changed and the error is returned.
+func (t *DurableTree) Delete(item pair.Pair) (pair.Pair, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delete(t.tr.Get(item))
+}
+
+// DeleteMin removes the smallest item in the tree and returns it.
+// If no such item exists, returns nil.
+func (t *DurableTree) DeleteMin() (pair.Pair, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delete(t.tr.Min())
+}
+
+// DeleteMax removes the largest item in the tree and returns it.
+// If no such item exists, returns nil.
+func (t *DurableTree) DeleteMax() (pair.Pair, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delete(t.tr.Max())
+}
+
+// delete logs and removes an item that is in the tree.  The caller must hold
+// the write lock.
+func (t *DurableTree) delete(item pair.Pair) (pair.Pair, error) {
+	if item == nilPair {
+		return nilPair, nil
+	}
+	if err := t.append(opDelete, item.Key(), nil); err != nil {
+		return nilPair, err
+	}
+	item = t.tr.Delete(item)
+	t.maybeCompact()
+	return item, nil
+}
+
+// Compact writes a snapshot of the tree and empties the log.  The snapshot
+// is written to a temporary file that is flushed and then renamed over the
+// previous snapshot, so a crash at any point leaves a snapshot and log that
+// together hold every logged write.
+func (t *DurableTree) Compact() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.log == nil {
+		return ErrClosed
+	}
+	return t.compact()
+}
+
+func (t *DurableTree) compact() error {
+	if t.err != nil {
+		return t.err
+	}
+	tmp := filepath.Join(t.dir, durableSnapshot+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = t.tr.WriteTo(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(t.dir, durableSnapshot))
+	}
+	if err == nil {
+		err = syncDir(t.dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// The snapshot holds every write in the log, so the log can be emptied.
+	// Should this fail, replaying the log over the snapshot gives the same
+	// tree, since every record sets or deletes a whole pair.
+	if err := t.log.Truncate(0); err != nil {
+		t.err = err
+		return err
+	}
+	if _, err := t.log.Seek(0, 0); err != nil {
+		t.err = err
+		return err
+	}
+	if err := t.log.Sync(); err != nil {
+		t.err = err
+		return err
+	}
+	t.size, t.dirty = 0, false
+	t.compactAt = t.opts.CompactSize
+	return nil
+}
+
+// Sync flushes the log to stable storage, regardless of the sync policy.
+func (t *DurableTree) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.log == nil {
+		return ErrClosed
+	}
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.log.Sync(); err != nil {
+		t.err = err
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// Close flushes and closes the log.  The tree can still be read after it is
+// closed, but writes return ErrClosed.
+func (t *DurableTree) Close() error {
+	// The sync loop takes the lock, so it is stopped before closing the log,
+	// with the lock released while waiting for it.
+	t.mu.Lock()
+	stop := t.stop
+	t.stop = nil
+	t.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-t.done
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.log == nil {
+		return ErrClosed
+	}
+	err := t.log.Sync()
+	if cerr := t.log.Close(); err == nil {
+		err = cerr
+	}
+	t.log = nil
+	return err
+}
+
+// Snapshot returns a copy of the tree as it is now.  See SyncTree.Snapshot.
+func (t *DurableTree) Snapshot() *PairTree {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.Clone()
+}
+
+// Get looks for the key item in the tree, returning it.  It returns nil if
+// unable to find that item.
+func (t *DurableTree) Get(key pair.Pair) pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Get(key)
+}
+
+// Has returns true if the given key is in the tree.
+func (t *DurableTree) Has(key pair.Pair) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Has(key)
+}
+
+// Len returns the number of items currently in the tree.
+func (t *DurableTree) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Len()
+}
+
+// Min returns the smallest item in the tree, or nil if the tree is empty.
+func (t *DurableTree) Min() pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Min()
+}
+
+// Max returns the largest item in the tree, or nil if the tree is empty.
+func (t *DurableTree) Max() pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Max()
+}
+
+// Ascend calls the iterator for every value in the tree within the range
+// [first, last], until iterator returns false.  The iterator must not write
+// to the tree.
+func (t *DurableTree) Ascend(iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.Ascend(iterator)
+}
+
+// AscendRange calls the iterator for every value in the tree within the range
+// [greaterOrEqual, lessThan), until iterator returns false.  The iterator must
+// not write to the tree.
+func (t *DurableTree) AscendRange(greaterOrEqual, lessThan pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// Descend calls the iterator for every value in the tree within the range
+// [last, first], until iterator returns false.  The iterator must not write
+// to the tree.
+func (t *DurableTree) Descend(iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.Descend(iterator)
+}
+
+// DescendRange calls the iterator for every value in the tree within the range
+// [lessOrEqual, greaterThan), until iterator returns false.  The iterator must
+// not write to the tree.
+func (t *DurableTree) DescendRange(lessOrEqual, greaterThan pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.DescendRange(lessOrEqual, greaterThan, iterator)
+}
@@ -0,0 +1,258 @@
+package pairtree
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func durableInts(t *testing.T, tr *DurableTree) []int {
+	t.Helper()
+	return treeInts(tr.Snapshot())
+}
+
+func TestDurableTree(t *testing.T) {
+	dir := t.TempDir()
+	opts := DurableOptions{Options: Options{Less: lessFn, Degree: 3}}
+	tr, err := OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := tr.ReplaceOrInsert(intVal(i, "a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if _, err := tr.Delete(Int(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if item, err := tr.DeleteMin(); err != nil || PairInt(item) != 1 {
+		t.Fatalf("expected to delete 1, got %v %v", item, err)
+	}
+	if item, err := tr.DeleteMax(); err != nil || PairInt(item) != 99 {
+		t.Fatalf("expected to delete 99, got %v %v", item, err)
+	}
+	if _, err := tr.ReplaceOrInsert(intVal(3, "b")); err != nil {
+		t.Fatal(err)
+	}
+	want := durableInts(t, tr)
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.ReplaceOrInsert(Int(0)); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	// Replay the log.
+	tr, err = OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := durableInts(t, tr); !intsEqual(got, want) {
+		t.Fatalf("replay: expected %v, got %v", want, got)
+	}
+	if string(tr.Get(Int(3)).Value()) != "b" {
+		t.Fatalf("replay lost the replaced value of 3")
+	}
+
+	// Compact, then write more and replay the snapshot and the log.
+	if err := tr.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, durableLog)); err != nil || fi.Size() != 0 {
+		t.Fatalf("expected an empty log after compaction, got %v %v", fi, err)
+	}
+	tr.ReplaceOrInsert(Int(1000))
+	tr.Delete(Int(3))
+	want = durableInts(t, tr)
+	tr.Close()
+	tr, err = OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := durableInts(t, tr); !intsEqual(got, want) {
+		t.Fatalf("compacted replay: expected %v, got %v", want, got)
+	}
+	checkTree(t, tr.Snapshot())
+	tr.Close()
+
+	// Reopening with another degree rebuilds the snapshot with that degree.
+	opts.Degree = 5
+	tr, err = OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap := tr.Snapshot(); snap.degree != 5 || !intsEqual(treeInts(snap), want) {
+		t.Fatalf("expected degree 5 and %v, got degree %d and %v", want, snap.degree, treeInts(snap))
+	}
+	checkTree(t, tr.Snapshot())
+	tr.Close()
+}
+
+func TestDurableTreeTornTail(t *testing.T) {
+	dir := t.TempDir()
+	opts := DurableOptions{Options: Options{Less: lessFn}, Sync: SyncNever}
+	tr, err := OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int64
+	for i := 0; i < 10; i++ {
+		tr.ReplaceOrInsert(intVal(i, "value"))
+		sizes = append(sizes, tr.size)
+	}
+	tr.Close()
+	path := filepath.Join(dir, durableLog)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a bit in the payload of the ninth record.
+	flipped := append([]byte{}, data...)
+	flipped[sizes[7]+logHeaderSize] ^= 1
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"partial header", data[:sizes[6]+3], 7},
+		{"partial payload", data[:sizes[4]-1], 4},
+		{"bad checksum", flipped, 8},
+		{"garbage", append(append([]byte{}, data...), 1, 0, 0, 0, 0, 0, 0, 0, 9), 10},
+	} {
+		if err := os.WriteFile(path, tc.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tr, err := OpenDurable(dir, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := durableInts(t, tr); !intsEqual(got, intRange(0, tc.want)) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, intRange(0, tc.want), got)
+		}
+		// The torn tail is truncated, so new writes follow the valid records.
+		tr.ReplaceOrInsert(Int(100))
+		tr.Close()
+		tr, err = OpenDurable(dir, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tr.Len() != tc.want+1 || !tr.Has(Int(100)) {
+			t.Fatalf("%s: write after truncation was lost", tc.name)
+		}
+		tr.Close()
+	}
+}
+
+func TestDurableTreeSyncPolicies(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		dir := t.TempDir()
+		opts := DurableOptions{
+			Options:      Options{Less: lessFn},
+			Sync:         policy,
+			SyncInterval: time.Millisecond,
+			CompactSize:  1 << 10,
+		}
+		tr, err := OpenDurable(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 500; i++ {
+			if _, err := tr.ReplaceOrInsert(Int(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tr.size >= opts.CompactSize {
+			t.Fatalf("policy %d: log was not compacted, size %d", policy, tr.size)
+		}
+		if err := tr.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		tr.Close()
+		tr, err = OpenDurable(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := durableInts(t, tr); !intsEqual(got, intRange(0, 500)) {
+			t.Fatalf("policy %d: expected 500 items, got %d", policy, len(got))
+		}
+		tr.Close()
+	}
+}
+
+func TestDurableTreeConcurrentClose(t *testing.T) {
+	opts := DurableOptions{
+		Options:      Options{Less: lessFn},
+		Sync:         SyncInterval,
+		SyncInterval: time.Millisecond,
+	}
+	tr, err := OpenDurable(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.ReplaceOrInsert(Int(1))
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = tr.Close()
+		}(i)
+	}
+	wg.Wait()
+	var closed int
+	for _, err := range errs {
+		if err == nil {
+			closed++
+		} else if err != ErrClosed {
+			t.Fatal(err)
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("expected one Close to succeed, got %d", closed)
+	}
+}
+
+func TestDurableTreeCompactFailure(t *testing.T) {
+	dir := t.TempDir()
+	opts := DurableOptions{Options: Options{Less: lessFn}, Sync: SyncInterval, SyncInterval: -time.Second}
+	if _, err := OpenDurable(dir, opts); err == nil {
+		t.Fatal("expected an error for a negative SyncInterval")
+	}
+	opts = DurableOptions{Options: Options{Less: lessFn}, CompactSize: 1 << 10}
+	tr, err := OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	// A directory in the way of the temporary snapshot fails compaction.
+	tmp := filepath.Join(dir, durableSnapshot+".tmp")
+	if err := os.Mkdir(tmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := tr.ReplaceOrInsert(Int(i)); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if item, err := tr.Delete(Int(0)); err != nil || PairInt(item) != 0 {
+		t.Fatalf("expected to delete 0, got %v %v", item, err)
+	}
+	if got := durableInts(t, tr); !intsEqual(got, intRange(1, 200)) {
+		t.Fatalf("expected 199 items, got %v", got)
+	}
+	if err := tr.Compact(); err == nil {
+		t.Fatal("expected Compact to report the failure")
+	}
+	if err := os.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Compact(); err != nil || tr.size != 0 {
+		t.Fatalf("expected an empty log after compaction, got size %d and %v", tr.size, err)
+	}
+}
@@ -0,0 +1,116 @@
+//go:build go1.23
+
+package pairtree
+
+import (
+	"iter"
+
+	"github.com/tidwall/pair"
+)
+
+// keyValues adapts a key/value yield function to the pairs of a tree.
+func keyValues(yield func(key, value []byte) bool) func(item pair.Pair) bool {
+	return func(item pair.Pair) bool {
+		return yield(item.Key(), item.Value())
+	}
+}
+
+// All returns an iterator over the keys and values of every pair in the tree,
+// in ascending order.
+func (t *PairTree) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		t.Ascend(keyValues(yield))
+	}
+}
+
+// Backward returns an iterator over the keys and values of every pair in the
+// tree, in descending order.
+func (t *PairTree) Backward() iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		t.Descend(keyValues(yield))
+	}
+}
+
+// Range returns an iterator over the keys and values of the pairs in the
+// range [greaterOrEqual, lessThan), in ascending order.  A nil bound leaves
+// that end of the range open.
+func (t *PairTree) Range(greaterOrEqual, lessThan pair.Pair) iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		t.AscendRange(greaterOrEqual, lessThan, keyValues(yield))
+	}
+}
+
+// RangeBackward returns an iterator over the keys and values of the pairs in
+// the range (greaterThan, lessOrEqual], in descending order.  A nil bound
+// leaves that end of the range open.
+func (t *PairTree) RangeBackward(lessOrEqual, greaterThan pair.Pair) iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		t.DescendRange(lessOrEqual, greaterThan, keyValues(yield))
+	}
+}
+
+// Pairs returns an iterator over every pair in the tree, in ascending order.
+func (t *PairTree) Pairs() iter.Seq[pair.Pair] {
+	return t.Ascend
+}
+
+// PairsBackward returns an iterator over every pair in the tree, in
+// descending order.
+func (t *PairTree) PairsBackward() iter.Seq[pair.Pair] {
+	return t.Descend
+}
+
+// PairsRange returns an iterator over the pairs in the range
+// [greaterOrEqual, lessThan), in ascending order.  A nil bound leaves that end
+// of the range open.
+func (t *PairTree) PairsRange(greaterOrEqual, lessThan pair.Pair) iter.Seq[pair.Pair] {
+	return func(yield func(pair.Pair) bool) {
+		t.AscendRange(greaterOrEqual, lessThan, yield)
+	}
+}
+
+// PairsRangeBackward returns an iterator over the pairs in the range
+// (greaterThan, lessOrEqual], in descending order.  A nil bound leaves that
+// end of the range open.
+func (t *PairTree) PairsRangeBackward(lessOrEqual, greaterThan pair.Pair) iter.Seq[pair.Pair] {
+	return func(yield func(pair.Pair) bool) {
+		t.DescendRange(lessOrEqual, greaterThan, yield)
+	}
+}
+
+// All returns an iterator over every key and value in the tree, in ascending
+// order.
+func (t *TreeG[K, V]) All() iter.Seq2[K, V] {
+	return t.Ascend
+}
+
+// Backward returns an iterator over every key and value in the tree, in
+// descending order.
+func (t *TreeG[K, V]) Backward() iter.Seq2[K, V] {
+	return t.Descend
+}
+
+// Keys returns an iterator over every key in the tree, in ascending order.
+func (t *TreeG[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		t.Ascend(func(key K, value V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Range returns an iterator over the keys and values in the range
+// [greaterOrEqual, lessThan), in ascending order.
+func (t *TreeG[K, V]) Range(greaterOrEqual, lessThan K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		t.AscendRange(greaterOrEqual, lessThan, yield)
+	}
+}
+
+// RangeBackward returns an iterator over the keys and values in the range
+// (greaterThan, lessOrEqual], in descending order.
+func (t *TreeG[K, V]) RangeBackward(lessOrEqual, greaterThan K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		t.DescendRange(lessOrEqual, greaterThan, yield)
+	}
+}
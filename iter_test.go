@@ -0,0 +1,98 @@
+//go:build go1.23
+
+package pairtree
+
+import (
+	"fmt"
+	"iter"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+// seqInts returns the integers yielded by seq in order.
+func seqInts(seq iter.Seq[pair.Pair]) (out []int) {
+	for item := range seq {
+		out = append(out, PairInt(item))
+	}
+	return
+}
+
+func TestIterators(t *testing.T) {
+	tr := New(lessFn)
+	for _, v := range rand.Perm(100) {
+		tr.ReplaceOrInsert(intVal(v, fmt.Sprint(v)))
+	}
+	var got []int
+	for k, v := range tr.All() {
+		i := PairInt(pair.New(k, nil))
+		if string(v) != fmt.Sprint(i) {
+			t.Fatalf("key %d has value %q", i, v)
+		}
+		got = append(got, i)
+	}
+	if !intsEqual(got, intRange(0, 100)) {
+		t.Fatalf("all mismatch: %v", got)
+	}
+	got = got[:0]
+	for k := range tr.Range(Int(10), Int(20)) {
+		got = append(got, PairInt(pair.New(k, nil)))
+	}
+	if !intsEqual(got, intRange(10, 20)) {
+		t.Fatalf("range mismatch: %v", got)
+	}
+	got = got[:0]
+	for k := range tr.RangeBackward(Int(90), nilPair) {
+		if len(got) == 5 {
+			break
+		}
+		got = append(got, PairInt(pair.New(k, nil)))
+	}
+	if !intsEqual(got, intRange(90, 85)) {
+		t.Fatalf("range backward mismatch: %v", got)
+	}
+	if all := seqInts(tr.Pairs()); !intsEqual(all, intRange(0, 100)) {
+		t.Fatalf("pairs mismatch: %v", all)
+	}
+	if all := seqInts(tr.PairsBackward()); !intsEqual(all, intRange(99, -1)) {
+		t.Fatalf("pairs backward mismatch: %v", all)
+	}
+	if all := seqInts(tr.PairsRange(Int(50), nilPair)); !intsEqual(all, intRange(50, 100)) {
+		t.Fatalf("pairs range mismatch: %v", all)
+	}
+	if all := seqInts(tr.PairsRangeBackward(Int(49), nilPair)); !intsEqual(all, intRange(49, -1)) {
+		t.Fatalf("pairs range backward mismatch: %v", all)
+	}
+}
+
+func TestTreeGIterators(t *testing.T) {
+	tr := NewG[int, string](2, intCmp)
+	for i := 0; i < 50; i++ {
+		tr.Set(i, fmt.Sprint(i))
+	}
+	if keys := slices.Collect(tr.Keys()); !intsEqual(keys, intRange(0, 50)) {
+		t.Fatalf("keys mismatch: %v", keys)
+	}
+	var got []int
+	for k, v := range tr.Range(5, 15) {
+		if v != fmt.Sprint(k) {
+			t.Fatalf("key %d has value %q", k, v)
+		}
+		got = append(got, k)
+	}
+	for k := range tr.RangeBackward(40, 35) {
+		got = append(got, k)
+	}
+	if want := append(intRange(5, 15), intRange(40, 35)...); !intsEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	got = got[:0]
+	for k := range tr.Backward() {
+		got = append(got, k)
+	}
+	if !intsEqual(got, intRange(49, -1)) {
+		t.Fatalf("backward mismatch: %v", got)
+	}
+}
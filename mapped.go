@@ -0,0 +1,638 @@
+package pairtree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/tidwall/pair"
+)
+
+// A mapped file holds an immutable B-tree that is read in place, without
+// being deserialized.  It is made up of a header, the nodes of the tree in
+// post-order, so that every node follows its children, and a trailer:
+//
+//	magic    "PTRM"
+//	version  1 byte, then 3 bytes of padding
+//	nodes    see below
+//	root     8 bytes, offset of the root node, or zero for an empty tree
+//	count    8 bytes, number of pairs
+//	checksum 4 bytes, CRC-32C of everything before it
+//	magic    "PTRM"
+//
+// A node holding n pairs is laid out as:
+//
+//	n        4 bytes
+//	flags    4 bytes, 1 for a leaf
+//	items    n+1 * 8 bytes, offsets of the start of each pair, followed by the
+//	         offset of the end of the last pair
+//	children n+1 * 8 bytes, offsets of the child nodes, absent for a leaf
+//	pairs    n * (uvarint key length, key, value)
+//
+// All integers are little endian, and all offsets are from the start of the
+// file.
+const (
+	mappedMagic       = "PTRM"
+	mappedVersion     = 1
+	mappedHeaderSize  = 8
+	mappedTrailerSize = 24
+	mappedLeaf        = 1
+)
+
+// WriteMapped writes the tree to w in a layout that can be opened with
+// OpenMapped, returning the number of bytes written.
+func (t *PairTree) WriteMapped(w io.Writer) (int64, error) {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.New(castagnoli)}
+	sw.write([]byte{mappedMagic[0], mappedMagic[1], mappedMagic[2], mappedMagic[3],
+		mappedVersion, 0, 0, 0})
+	var root uint64
+	if t.root != nil && t.length > 0 {
+		root = writeMappedNode(sw, t.root)
+	}
+	sw.uint64(root)
+	sw.uint64(uint64(t.length))
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], sw.crc.Sum32())
+	sw.write(sum[:])
+	sw.write([]byte(mappedMagic))
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.n, sw.err
+}
+
+// writeMappedNode writes the subtree rooted at n, returning the offset of n.
+func writeMappedNode(sw *snapshotWriter, n *node) uint64 {
+	offsets := make([]uint64, len(n.children))
+	for i, child := range n.children {
+		offsets[i] = writeMappedNode(sw, child)
+	}
+	off := uint64(sw.n)
+	var buf [binary.MaxVarintLen64]byte
+	sw.uint32(uint32(len(n.items)))
+	if len(n.children) == 0 {
+		sw.uint32(mappedLeaf)
+	} else {
+		sw.uint32(0)
+	}
+	pos := off + 8 + uint64(len(n.items)+1+len(offsets))*8
+	for _, item := range n.items {
+		sw.uint64(pos)
+		key := item.Key()
+		pos += uint64(binary.PutUvarint(buf[:], uint64(len(key))) + len(key) + len(item.Value()))
+	}
+	sw.uint64(pos)
+	for _, child := range offsets {
+		sw.uint64(child)
+	}
+	for _, item := range n.items {
+		sw.bytes(item.Key())
+		sw.write(item.Value())
+	}
+	return off
+}
+
+// MappedTree is a read-only B-tree that is mapped into memory from a file
+// written by WriteMapped.  Opening it maps the file and verifies its
+// checksum and the bounds of its nodes, without building any nodes, and
+// lookups read the mapped pages in place.
+//
+// The keys and values returned by a MappedTree alias the mapped memory, so
+// they must not be modified, and must not be used after the tree is closed.
+// A pair.Pair always owns its memory and so cannot alias the mapping;
+// MappedCursor.Pair returns a copy for use with a PairTree.
+//
+// A MappedTree is safe for concurrent use by multiple goroutines, until it is
+// closed.
+type MappedTree struct {
+	data  []byte
+	unmap func() error
+	root  uint64
+	count int
+	cmp   func(a, b []byte) int
+}
+
+// OpenMapped opens a file written by WriteMapped.  The compare function must
+// order keys as the tree that wrote the file did.  A nil compare orders keys
+// with bytes.Compare, as a PairTree does by default.
+//
+// ErrCorrupt is returned if the file is malformed or fails its checksum.
+func OpenMapped(path string, compare func(a, b []byte) int) (*MappedTree, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if compare == nil {
+		compare = bytes.Compare
+	}
+	t := &MappedTree{data: data, unmap: unmap, cmp: compare}
+	if err := t.validate(); err != nil {
+		unmap()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *MappedTree) validate() error {
+	data := t.data
+	if len(data) < mappedHeaderSize+mappedTrailerSize ||
+		string(data[:len(mappedMagic)]) != mappedMagic ||
+		string(data[len(data)-len(mappedMagic):]) != mappedMagic {
+		return ErrCorrupt
+	}
+	if data[len(mappedMagic)] != mappedVersion {
+		return fmt.Errorf("pairtree: unsupported mapped file version %d", data[len(mappedMagic)])
+	}
+	trailer := data[len(data)-mappedTrailerSize:]
+	if crc32.Checksum(data[:len(data)-8], castagnoli) != binary.LittleEndian.Uint32(trailer[16:]) {
+		return ErrCorrupt
+	}
+	root := binary.LittleEndian.Uint64(trailer)
+	count := binary.LittleEndian.Uint64(trailer[8:])
+	if (root == 0) != (count == 0) || root >= uint64(len(data)-mappedTrailerSize) ||
+		count > uint64(len(data)) {
+		return ErrCorrupt
+	}
+	t.root, t.count = root, int(count)
+	if root != 0 {
+		pairs, err := t.validateNode(root, 0)
+		if err != nil {
+			return err
+		}
+		if pairs != count {
+			return ErrCorrupt
+		}
+	}
+	return nil
+}
+
+// mappedMaxHeight bounds the height of a mapped tree.  A B-tree of degree 2
+// or more holding fewer than 2^63 pairs is never this tall.
+const mappedMaxHeight = 64
+
+// validateNode checks that the subtree at off can be read without going out
+// of bounds, returning its number of pairs.  Every child must precede its
+// parent, and every node must hold a pair, so the walk ends, and it stops
+// once it has seen more pairs than the trailer records, so a node that is
+// the child of many others cannot make it take long.
+func (t *MappedTree) validateNode(off uint64, depth int) (uint64, error) {
+	end := uint64(len(t.data) - mappedTrailerSize)
+	if depth >= mappedMaxHeight || off < mappedHeaderSize || off > end-8 {
+		return 0, ErrCorrupt
+	}
+	n := t.node(off)
+	flags := binary.LittleEndian.Uint32(t.data[off+4:])
+	if n.n == 0 || flags&^mappedLeaf != 0 {
+		return 0, ErrCorrupt
+	}
+	slots := uint64(n.n) + 1
+	if !n.leaf {
+		slots *= 2
+	}
+	if slots > (end-off-8)/8 {
+		return 0, ErrCorrupt
+	}
+	prev := off + 8 + slots*8
+	for i := 0; i <= n.n; i++ {
+		p := binary.LittleEndian.Uint64(t.data[off+8+uint64(i)*8:])
+		if p < prev || p > end {
+			return 0, ErrCorrupt
+		}
+		if i > 0 {
+			b := t.data[prev:p]
+			size, m := binary.Uvarint(b)
+			if m <= 0 || size > uint64(len(b)-m) {
+				return 0, ErrCorrupt
+			}
+		}
+		prev = p
+	}
+	pairs := uint64(n.n)
+	if n.leaf {
+		return pairs, nil
+	}
+	for i := 0; i <= n.n; i++ {
+		child := binary.LittleEndian.Uint64(t.data[off+8+uint64(n.n+1+i)*8:])
+		if child >= off {
+			return 0, ErrCorrupt
+		}
+		m, err := t.validateNode(child, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		if pairs += m; pairs > uint64(t.count) {
+			return 0, ErrCorrupt
+		}
+	}
+	return pairs, nil
+}
+
+// Close unmaps the file.  The tree, and any keys and values returned by it,
+// must not be used afterwards.
+func (t *MappedTree) Close() error {
+	if t.unmap == nil {
+		return ErrClosed
+	}
+	err := t.unmap()
+	t.unmap, t.data, t.root, t.count = nil, nil, 0, 0
+	return err
+}
+
+// Len returns the number of pairs in the tree.
+func (t *MappedTree) Len() int {
+	return t.count
+}
+
+// mappedNode is a node of a MappedTree.
+type mappedNode struct {
+	off  uint64
+	n    int // number of items
+	leaf bool
+}
+
+func (t *MappedTree) node(off uint64) mappedNode {
+	return mappedNode{
+		off:  off,
+		n:    int(binary.LittleEndian.Uint32(t.data[off:])),
+		leaf: binary.LittleEndian.Uint32(t.data[off+4:])&mappedLeaf != 0,
+	}
+}
+
+// slots returns the number of children and items of n, which a cursor steps
+// through in the same way as the nodes of a PairTree.
+func (n mappedNode) slots() int {
+	if n.leaf {
+		return n.n
+	}
+	return 2*n.n + 1
+}
+
+func (t *MappedTree) child(n mappedNode, i int) mappedNode {
+	p := n.off + 8 + uint64(n.n+1+i)*8
+	return t.node(binary.LittleEndian.Uint64(t.data[p:]))
+}
+
+// item returns the key and value of the i'th item of n.
+func (t *MappedTree) item(n mappedNode, i int) (key, value []byte) {
+	p := n.off + 8 + uint64(i)*8
+	start := binary.LittleEndian.Uint64(t.data[p:])
+	end := binary.LittleEndian.Uint64(t.data[p+8:])
+	b := t.data[start:end:end]
+	size, m := binary.Uvarint(b)
+	k := m + int(size)
+	return b[m:k:k], b[k:]
+}
+
+// find returns the index where key should be inserted into n, and whether
+// the key is already there.
+func (t *MappedTree) find(n mappedNode, key []byte) (int, bool) {
+	i := sort.Search(n.n, func(i int) bool {
+		k, _ := t.item(n, i)
+		return t.cmp(key, k) <= 0
+	})
+	if i < n.n {
+		k, _ := t.item(n, i)
+		return i, t.cmp(key, k) == 0
+	}
+	return i, false
+}
+
+// Get returns the value for key, and whether the key was found.
+func (t *MappedTree) Get(key []byte) (value []byte, ok bool) {
+	if t.root == 0 {
+		return nil, false
+	}
+	n := t.node(t.root)
+	for {
+		i, found := t.find(n, key)
+		if found {
+			_, value = t.item(n, i)
+			return value, true
+		}
+		if n.leaf {
+			return nil, false
+		}
+		n = t.child(n, i)
+	}
+}
+
+// Has returns true if the given key is in the tree.
+func (t *MappedTree) Has(key []byte) bool {
+	_, ok := t.Get(key)
+	return ok
+}
+
+// below returns true if key is below the bound hi, treating nil as unbounded.
+func (t *MappedTree) below(key, hi []byte) bool {
+	return hi == nil || t.cmp(key, hi) < 0
+}
+
+// above returns true if key is above the bound lo, treating nil as unbounded.
+func (t *MappedTree) above(key, lo []byte) bool {
+	return lo == nil || t.cmp(key, lo) > 0
+}
+
+// AscendRange calls the iterator for every pair in the tree within the range
+// [greaterOrEqual, lessThan), until iterator returns false.  A nil bound
+// leaves that end of the range open, as it does for a PairTree.
+func (t *MappedTree) AscendRange(greaterOrEqual, lessThan []byte, iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.seekFrom(greaterOrEqual); ok && t.below(c.key, lessThan); ok = c.Next() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// AscendLessThan calls the iterator for every pair in the tree within the
+// range [first, pivot), until iterator returns false.  A nil pivot leaves the
+// range open.
+func (t *MappedTree) AscendLessThan(pivot []byte, iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.First(); ok && t.below(c.key, pivot); ok = c.Next() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// AscendGreaterOrEqual calls the iterator for every pair in the tree within
+// the range [pivot, last], until iterator returns false.  A nil pivot leaves
+// the range open.
+func (t *MappedTree) AscendGreaterOrEqual(pivot []byte, iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.seekFrom(pivot); ok; ok = c.Next() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// Ascend calls the iterator for every pair in the tree within the range
+// [first, last], until iterator returns false.
+func (t *MappedTree) Ascend(iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.First(); ok; ok = c.Next() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// DescendRange calls the iterator for every pair in the tree within the range
+// [lessOrEqual, greaterThan), until iterator returns false.  A nil bound
+// leaves that end of the range open, as it does for a PairTree.
+func (t *MappedTree) DescendRange(lessOrEqual, greaterThan []byte, iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.seekLEFrom(lessOrEqual); ok && t.above(c.key, greaterThan); ok = c.Prev() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// DescendLessOrEqual calls the iterator for every pair in the tree within the
+// range [pivot, first], until iterator returns false.  A nil pivot leaves the
+// range open.
+func (t *MappedTree) DescendLessOrEqual(pivot []byte, iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.seekLEFrom(pivot); ok; ok = c.Prev() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// DescendGreaterThan calls the iterator for every pair in the tree within the
+// range [last, pivot), until iterator returns false.  A nil pivot leaves the
+// range open.
+func (t *MappedTree) DescendGreaterThan(pivot []byte, iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.Last(); ok && t.above(c.key, pivot); ok = c.Prev() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// Descend calls the iterator for every pair in the tree within the range
+// [last, first], until iterator returns false.
+func (t *MappedTree) Descend(iterator func(key, value []byte) bool) {
+	c := t.Cursor()
+	for ok := c.Last(); ok; ok = c.Prev() {
+		if !iterator(c.key, c.value) {
+			return
+		}
+	}
+}
+
+// MappedCursor traverses the pairs of a MappedTree in sorted order.  Each
+// move returns whether the cursor is positioned on a pair, which Key and
+// Value then return.
+type MappedCursor struct {
+	t     *MappedTree
+	stack []mappedStackPair
+	key   []byte
+	value []byte
+	ok    bool
+}
+
+type mappedStackPair struct {
+	n mappedNode // current node
+	i int        // index of the next child/item.
+}
+
+// Cursor returns a new cursor used to traverse over the pairs in the tree.
+func (t *MappedTree) Cursor() *MappedCursor {
+	return &MappedCursor{t: t}
+}
+
+// First moves the cursor to the first pair in the tree.
+func (c *MappedCursor) First() bool {
+	c.stack = c.stack[:0]
+	if c.t.root == 0 {
+		return c.track(mappedNode{}, -1)
+	}
+	n := c.t.node(c.t.root)
+	c.stack = append(c.stack, mappedStackPair{n: n})
+	for !n.leaf {
+		n = c.t.child(n, 0)
+		c.stack = append(c.stack, mappedStackPair{n: n})
+	}
+	return c.track(n, 0)
+}
+
+// Next moves the cursor to the next pair.
+func (c *MappedCursor) Next() bool {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		top.i++
+		n, i := top.n, top.i
+		switch {
+		case i >= n.slots():
+			c.stack = c.stack[:len(c.stack)-1]
+		case n.leaf:
+			return c.track(n, i)
+		case i%2 == 1:
+			return c.track(n, i/2)
+		default:
+			c.stack = append(c.stack, mappedStackPair{n: c.t.child(n, i/2), i: -1})
+		}
+	}
+	return c.track(mappedNode{}, -1)
+}
+
+// Last moves the cursor to the last pair in the tree.
+func (c *MappedCursor) Last() bool {
+	c.stack = c.stack[:0]
+	if c.t.root == 0 {
+		return c.track(mappedNode{}, -1)
+	}
+	n := c.t.node(c.t.root)
+	c.stack = append(c.stack, mappedStackPair{n: n, i: n.slots() - 1})
+	for !n.leaf {
+		n = c.t.child(n, n.n)
+		c.stack = append(c.stack, mappedStackPair{n: n, i: n.slots() - 1})
+	}
+	return c.track(n, n.n-1)
+}
+
+// Prev moves the cursor to the previous pair.
+func (c *MappedCursor) Prev() bool {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		top.i--
+		n, i := top.n, top.i
+		switch {
+		case i < 0:
+			c.stack = c.stack[:len(c.stack)-1]
+		case n.leaf:
+			return c.track(n, i)
+		case i%2 == 1:
+			return c.track(n, i/2)
+		default:
+			child := c.t.child(n, i/2)
+			c.stack = append(c.stack, mappedStackPair{n: child, i: child.slots()})
+		}
+	}
+	return c.track(mappedNode{}, -1)
+}
+
+// Seek moves the cursor to the first pair with a key greater than or equal
+// to pivot.
+func (c *MappedCursor) Seek(pivot []byte) bool {
+	c.stack = c.stack[:0]
+	if c.t.root == 0 {
+		return c.track(mappedNode{}, -1)
+	}
+	n := c.t.node(c.t.root)
+	for {
+		i, found := c.t.find(n, pivot)
+		switch {
+		case found && n.leaf:
+			c.stack = append(c.stack, mappedStackPair{n: n, i: i})
+			return c.track(n, i)
+		case found:
+			c.stack = append(c.stack, mappedStackPair{n: n, i: i*2 + 1})
+			return c.track(n, i)
+		case n.leaf:
+			// Step forward from just before the insertion point.
+			c.stack = append(c.stack, mappedStackPair{n: n, i: i - 1})
+			return c.Next()
+		}
+		c.stack = append(c.stack, mappedStackPair{n: n, i: i * 2})
+		n = c.t.child(n, i)
+	}
+}
+
+// seekFrom seeks the first pair at or after the bound pivot, treating nil as
+// unbounded.
+func (c *MappedCursor) seekFrom(pivot []byte) bool {
+	if pivot == nil {
+		return c.First()
+	}
+	return c.Seek(pivot)
+}
+
+// seekLEFrom seeks the last pair at or before the bound pivot, treating nil
+// as unbounded.
+func (c *MappedCursor) seekLEFrom(pivot []byte) bool {
+	if pivot == nil {
+		return c.Last()
+	}
+	return c.SeekLE(pivot)
+}
+
+// SeekGT moves the cursor to the first pair with a key greater than pivot.
+func (c *MappedCursor) SeekGT(pivot []byte) bool {
+	if c.Seek(pivot) && c.t.cmp(pivot, c.key) == 0 {
+		return c.Next()
+	}
+	return c.ok
+}
+
+// SeekLE moves the cursor to the last pair with a key less than or equal to
+// pivot.
+func (c *MappedCursor) SeekLE(pivot []byte) bool {
+	if c.Seek(pivot) && c.t.cmp(pivot, c.key) == 0 {
+		return true
+	}
+	return c.stepBack()
+}
+
+// SeekLT moves the cursor to the last pair with a key less than pivot.
+func (c *MappedCursor) SeekLT(pivot []byte) bool {
+	c.Seek(pivot)
+	return c.stepBack()
+}
+
+// stepBack moves the cursor back from the pair found by Seek, or to the last
+// pair when no pair was found.
+func (c *MappedCursor) stepBack() bool {
+	if c.ok {
+		return c.Prev()
+	}
+	return c.Last()
+}
+
+// track records the i'th item of n as the current pair, or no pair when i is
+// negative.
+func (c *MappedCursor) track(n mappedNode, i int) bool {
+	if i < 0 || i >= n.n {
+		c.key, c.value, c.ok = nil, nil, false
+		return false
+	}
+	c.key, c.value = c.t.item(n, i)
+	c.ok = true
+	return true
+}
+
+// Valid returns true if the cursor is positioned on a pair.
+func (c *MappedCursor) Valid() bool {
+	return c.ok
+}
+
+// Key returns the key of the current pair, which aliases the mapped file, or
+// nil if the cursor is not positioned on a pair.
+func (c *MappedCursor) Key() []byte {
+	return c.key
+}
+
+// Value returns the value of the current pair, which aliases the mapped file,
+// or nil if the cursor is not positioned on a pair.
+func (c *MappedCursor) Value() []byte {
+	return c.value
+}
+
+// Pair returns a copy of the current pair that does not alias the mapped
+// file, or nil if the cursor is not positioned on a pair.
+func (c *MappedCursor) Pair() pair.Pair {
+	if !c.ok {
+		return nilPair
+	}
+	return pair.New(c.key, c.value)
+}
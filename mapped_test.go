@@ -0,0 +1,271 @@
+package pairtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+// intKeyCompare orders the keys of Int items as lessFn does.
+func intKeyCompare(a, b []byte) int {
+	x, y := int(binary.LittleEndian.Uint64(a)), int(binary.LittleEndian.Uint64(b))
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func writeMapped(t *testing.T, tr *PairTree) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tree")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tr.WriteMapped(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mappedInts(fn func(iterator func(key, value []byte) bool)) (out []int) {
+	fn(func(key, value []byte) bool {
+		out = append(out, int(binary.LittleEndian.Uint64(key)))
+		return true
+	})
+	return out
+}
+
+func TestMapped(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 2000} {
+		tr := NewWithOptions(Options{Degree: 3, Less: lessFn})
+		// Even numbers only, so that every odd pivot falls between items.
+		for _, v := range perm(n) {
+			i := PairInt(v) * 2
+			tr.ReplaceOrInsert(intVal(i, fmt.Sprint(i)))
+		}
+		m, err := OpenMapped(writeMapped(t, tr), intKeyCompare)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if m.Len() != n {
+			t.Fatalf("n=%d: expected length %d, got %d", n, n, m.Len())
+		}
+		for i := -1; i <= 2*n; i++ {
+			value, ok := m.Get(Int(i).Key())
+			if ok != (i >= 0 && i%2 == 0 && i < 2*n) || (ok && string(value) != fmt.Sprint(i)) {
+				t.Fatalf("n=%d: Get(%d) = %q, %v", n, i, value, ok)
+			}
+		}
+		if got, want := mappedInts(m.Ascend), treeInts(tr); !intsEqual(got, want) {
+			t.Fatalf("n=%d: Ascend: expected %v, got %v", n, want, got)
+		}
+		if got, want := mappedInts(m.Descend), intsReversed(treeInts(tr)); !intsEqual(got, want) {
+			t.Fatalf("n=%d: Descend: expected %v, got %v", n, want, got)
+		}
+		// A nil bound leaves that end of the range open.
+		key := func(item pair.Pair) []byte {
+			if item == nilPair {
+				return nil
+			}
+			return item.Key()
+		}
+		bounds := [][2]pair.Pair{{Int(n / 2), Int(n + 1)}, {nilPair, Int(n + 1)},
+			{Int(n / 2), nilPair}, {nilPair, nilPair}}
+		for _, b := range bounds {
+			lo, hi := b[0], b[1]
+			for _, tc := range []struct {
+				name string
+				got  func(func(key, value []byte) bool)
+				want func(func(item pair.Pair) bool)
+			}{
+				{"AscendRange",
+					func(fn func(key, value []byte) bool) { m.AscendRange(key(lo), key(hi), fn) },
+					func(fn func(item pair.Pair) bool) { tr.AscendRange(lo, hi, fn) }},
+				{"AscendLessThan",
+					func(fn func(key, value []byte) bool) { m.AscendLessThan(key(hi), fn) },
+					func(fn func(item pair.Pair) bool) { tr.AscendLessThan(hi, fn) }},
+				{"AscendGreaterOrEqual",
+					func(fn func(key, value []byte) bool) { m.AscendGreaterOrEqual(key(lo), fn) },
+					func(fn func(item pair.Pair) bool) { tr.AscendGreaterOrEqual(lo, fn) }},
+				{"DescendRange",
+					func(fn func(key, value []byte) bool) { m.DescendRange(key(hi), key(lo), fn) },
+					func(fn func(item pair.Pair) bool) { tr.DescendRange(hi, lo, fn) }},
+				{"DescendLessOrEqual",
+					func(fn func(key, value []byte) bool) { m.DescendLessOrEqual(key(hi), fn) },
+					func(fn func(item pair.Pair) bool) { tr.DescendLessOrEqual(hi, fn) }},
+				{"DescendGreaterThan",
+					func(fn func(key, value []byte) bool) { m.DescendGreaterThan(key(lo), fn) },
+					func(fn func(item pair.Pair) bool) { tr.DescendGreaterThan(lo, fn) }},
+			} {
+				var want []int
+				tc.want(func(item pair.Pair) bool {
+					want = append(want, PairInt(item))
+					return true
+				})
+				if got := mappedInts(tc.got); !intsEqual(got, want) {
+					t.Fatalf("n=%d: %s(%v, %v): expected %v, got %v",
+						n, tc.name, IntStr(lo), IntStr(hi), want, got)
+				}
+			}
+		}
+
+		// Every cursor move matches a cursor over the tree.
+		mc, tc := m.Cursor(), tr.Cursor()
+		check := func(op string, pivot int, ok bool) {
+			t.Helper()
+			if ok != tc.Valid() || (ok && (!bytes.Equal(mc.Key(), tc.Key()) ||
+				!bytes.Equal(mc.Value(), tc.Value()) || PairInt(mc.Pair()) != PairInt(tc.Item()))) {
+				t.Fatalf("n=%d: %s(%d): expected %v %v, got %v %v",
+					n, op, pivot, tc.Valid(), tc.Key(), ok, mc.Key())
+			}
+		}
+		tc.First()
+		check("First", 0, mc.First())
+		for ok := true; ok; {
+			tc.Next()
+			ok = mc.Next()
+			check("Next", 0, ok)
+		}
+		tc.Last()
+		check("Last", 0, mc.Last())
+		for ok := true; ok; {
+			tc.Prev()
+			ok = mc.Prev()
+			check("Prev", 0, ok)
+		}
+		for i := -1; i <= 2*n+1; i++ {
+			pivot := Int(i)
+			tc.Seek(pivot)
+			check("Seek", i, mc.Seek(pivot.Key()))
+			tc.SeekGT(pivot)
+			check("SeekGT", i, mc.SeekGT(pivot.Key()))
+			tc.SeekLE(pivot)
+			check("SeekLE", i, mc.SeekLE(pivot.Key()))
+			tc.SeekLT(pivot)
+			check("SeekLT", i, mc.SeekLT(pivot.Key()))
+		}
+		if err := m.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Close(); err != ErrClosed {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	}
+}
+
+func intsReversed(a []int) []int {
+	out := make([]int, len(a))
+	for i, v := range a {
+		out[len(a)-1-i] = v
+	}
+	return out
+}
+
+func TestMappedDefaultCompare(t *testing.T) {
+	tr := New(nil)
+	for _, s := range []string{"b", "", "ab", "a", "ba"} {
+		tr.ReplaceOrInsert(pair.New([]byte(s), []byte("v"+s)))
+	}
+	m, err := OpenMapped(writeMapped(t, tr), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var keys []string
+	m.Ascend(func(key, value []byte) bool {
+		if string(value) != "v"+string(key) {
+			t.Fatalf("key %q has value %q", key, value)
+		}
+		keys = append(keys, string(key))
+		return true
+	})
+	if fmt.Sprint(keys) != fmt.Sprint([]string{"", "a", "ab", "b", "ba"}) {
+		t.Fatalf("unexpected order %q", keys)
+	}
+	if !m.Has(nil) || m.Has([]byte("c")) {
+		t.Fatal("unexpected Has result")
+	}
+}
+
+func TestMappedCorrupt(t *testing.T) {
+	tr := New(lessFn)
+	for i := 0; i < 100; i++ {
+		tr.ReplaceOrInsert(intVal(i, "value"))
+	}
+	path := writeMapped(t, tr)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i += 1 + i/8 {
+		bad := append([]byte(nil), data...)
+		bad[i] ^= 0x40
+		if err := os.WriteFile(path, bad, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := OpenMapped(path, intKeyCompare); err == nil {
+			m.Close()
+			t.Fatalf("expected an error with byte %d corrupted", i)
+		}
+	}
+	for _, n := range []int{0, 10, len(data) - 1} {
+		if err := os.WriteFile(path, data[:n], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenMapped(path, intKeyCompare); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt for %d bytes, got %v", n, err)
+		}
+	}
+}
+
+func TestMappedCorruptChecksummed(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 2, Less: lessFn})
+	for i := 0; i < 50; i++ {
+		tr.ReplaceOrInsert(intVal(i, "value"))
+	}
+	path := writeMapped(t, tr)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt each byte of the nodes, root and count, with a checksum to match.
+	// The file must either fail to open, or be readable without panicking.
+	trailer := len(data) - mappedTrailerSize
+	for i := mappedHeaderSize; i < trailer+16; i++ {
+		for _, mask := range []byte{0x01, 0x40, 0xff} {
+			bad := append([]byte(nil), data...)
+			bad[i] ^= mask
+			binary.LittleEndian.PutUint32(bad[trailer+16:], crc32.Checksum(bad[:trailer+16], castagnoli))
+			if err := os.WriteFile(path, bad, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// Corrupt keys may be of any length, which intKeyCompare
+			// does not allow for.
+			m, err := OpenMapped(path, nil)
+			if err != nil {
+				if err != ErrCorrupt {
+					t.Fatalf("byte %d: expected ErrCorrupt, got %v", i, err)
+				}
+				continue
+			}
+			m.Ascend(func(key, value []byte) bool { return true })
+			m.Descend(func(key, value []byte) bool { return true })
+			for k := -1; k <= 50; k++ {
+				m.Get(Int(k).Key())
+				m.Cursor().SeekLE(Int(k).Key())
+			}
+			m.Close()
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pairtree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math/bits"
+
+	"github.com/tidwall/pair"
+)
+
+// Hash is a digest of a set of pairs, used to check that two trees hold the
+// same pairs without comparing the pairs themselves.
+//
+// The hash of a set is the sum, modulo 2^256, of the SHA-256 digests of its
+// pairs, so it does not depend on the shape of the tree holding them, and
+// the hash of the union of two disjoint sets is the sum of their hashes.  An
+// empty set hashes to zero.  Being a sum, it is meant to detect differences
+// between honest replicas, and does not resist deliberately crafted pairs.
+type Hash [32]byte
+
+// add returns the sum of h and o, modulo 2^256.
+func (h Hash) add(o Hash) Hash {
+	var out Hash
+	var carry uint64
+	for i := 0; i < len(h); i += 8 {
+		var sum uint64
+		sum, carry = bits.Add64(binary.LittleEndian.Uint64(h[i:]), binary.LittleEndian.Uint64(o[i:]), carry)
+		binary.LittleEndian.PutUint64(out[i:], sum)
+	}
+	return out
+}
+
+// pairHasher hashes pairs, reusing its buffers.
+type pairHasher struct {
+	h   hash.Hash
+	buf [sha256.Size]byte
+}
+
+func newPairHasher() *pairHasher {
+	return &pairHasher{h: sha256.New()}
+}
+
+// sum returns the SHA-256 digest of the length-prefixed key of item followed
+// by its value.
+func (ph *pairHasher) sum(item pair.Pair) Hash {
+	key := ph.buf[:binary.PutUvarint(ph.buf[:], uint64(len(item.Key())))]
+	ph.h.Reset()
+	ph.h.Write(key)
+	ph.h.Write(item.Key())
+	ph.h.Write(item.Value())
+	var out Hash
+	ph.h.Sum(out[:0])
+	return out
+}
+
+// nodeHash returns the hash of the subtree rooted at n.  Hashes are computed
+// on first use and cached in the nodes, which share them with clones, and a
+// cached hash is discarded when its node is modified.  After a write only
+// the nodes on the path to the written item are rehashed.
+func nodeHash(n *node, ph *pairHasher) Hash {
+	if h := n.hash.Load(); h != nil {
+		return *h
+	}
+	var sum Hash
+	for _, item := range n.items {
+		sum = sum.add(ph.sum(item))
+	}
+	for _, child := range n.children {
+		sum = sum.add(nodeHash(child, ph))
+	}
+	n.hash.Store(&sum)
+	return sum
+}
+
+// RootHash returns the hash of all of the pairs in the tree.  Two trees hold
+// the same pairs when their root hashes are equal, whatever their degrees
+// or the order in which the pairs were written.
+//
+// Hashes are cached in the nodes of the tree, so RootHash is O(n) the first
+// time it is called, and O(w log n) after w writes.
+func (t *PairTree) RootHash() Hash {
+	if t.root == nil {
+		return Hash{}
+	}
+	return nodeHash(t.root, newPairHasher())
+}
+
+// HashRange returns the hash of the pairs in the tree within the range
+// [greaterOrEqual, lessThan).  A nil bound leaves that end of the range open.
+// It uses the cached hashes of the subtrees within the range, so it takes
+// O(log n) time once the tree has been hashed.
+func (t *PairTree) HashRange(greaterOrEqual, lessThan pair.Pair) Hash {
+	if t.root == nil {
+		return Hash{}
+	}
+	return t.hashRange(t.root, bound(greaterOrEqual), bound(lessThan), newPairHasher())
+}
+
+func (t *PairTree) hashRange(n *node, lo, hi optionalItem[pair.Pair], ph *pairHasher) Hash {
+	if !lo.valid && !hi.valid {
+		return nodeHash(n, ph)
+	}
+	i, j := 0, len(n.items)
+	if lo.valid {
+		i, _ = n.items.find(lo.item, &t.ordering)
+	}
+	if hi.valid {
+		j, _ = n.items.find(hi.item, &t.ordering)
+	}
+	var sum Hash
+	if i > j {
+		// An empty range, with lo above hi.
+		return sum
+	}
+	for _, item := range n.items[i:j] {
+		sum = sum.add(ph.sum(item))
+	}
+	if len(n.children) == 0 {
+		return sum
+	}
+	if i == j {
+		return sum.add(t.hashRange(n.children[i], lo, hi, ph))
+	}
+	sum = sum.add(t.hashRange(n.children[i], lo, empty[pair.Pair](), ph))
+	for _, child := range n.children[i+1 : j] {
+		sum = sum.add(nodeHash(child, ph))
+	}
+	return sum.add(t.hashRange(n.children[j], empty[pair.Pair](), hi, ph))
+}
+
+// RangeHasher hashes the pairs within a range, as PairTree.HashRange does.
+// For a replica in another process, it is implemented by asking that process
+// for the hash.
+type RangeHasher interface {
+	HashRange(greaterOrEqual, lessThan pair.Pair) Hash
+}
+
+// DiffRanges calls fn for each range [greaterOrEqual, lessThan) in which the
+// pairs of a and b differ, in ascending order, until fn returns false.  A
+// nil bound leaves that end of the range open.  Copying the pairs within
+// each range from one tree to the other makes the trees equal.  The tree a
+// must not be written to during the call, so to copy pairs into it from fn,
+// pass a clone of it instead.
+//
+// The ranges are found by comparing hashes top-down along the nodes of a,
+// only descending into the ranges whose hashes differ, and are narrowed down
+// to a single pair of a, or a gap between two pairs of a, wherever possible.
+// Each node of a whose range differs is split into a range per child, so the
+// number of calls to b.HashRange is O(d·degree·log n) for d differences.
+func DiffRanges(a *PairTree, b RangeHasher, fn func(greaterOrEqual, lessThan pair.Pair) bool) {
+	ph := newPairHasher()
+	if a.root == nil {
+		if b.HashRange(nilPair, nilPair) != (Hash{}) {
+			fn(nilPair, nilPair)
+		}
+		return
+	}
+	diffRanges(a.root, nilPair, nilPair, Hash{}, b, ph, fn)
+}
+
+// diffRanges compares the range [lo, hi) of b with the subtree rooted at n,
+// plus the pair of a at lo, if any, whose hash is first.  It returns false
+// once fn does.
+func diffRanges(n *node, lo, hi pair.Pair, first Hash, b RangeHasher, ph *pairHasher, fn func(lo, hi pair.Pair) bool) bool {
+	if first.add(nodeHash(n, ph)) == b.HashRange(lo, hi) {
+		return true
+	}
+	// Split the range at each pair of n.  The first part holds the first
+	// child, and each part after it one pair of n followed by a child.
+	start, startHash := lo, first
+	for i := 0; i <= len(n.items); i++ {
+		end := hi
+		if i < len(n.items) {
+			end = n.items[i]
+		}
+		var ok bool
+		if len(n.children) > 0 {
+			ok = diffRanges(n.children[i], start, end, startHash, b, ph, fn)
+		} else if startHash != b.HashRange(start, end) {
+			ok = fn(start, end)
+		} else {
+			ok = true
+		}
+		if !ok {
+			return false
+		}
+		if i < len(n.items) {
+			start, startHash = n.items[i], ph.sum(n.items[i])
+		}
+	}
+	return true
+}
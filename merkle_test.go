@@ -0,0 +1,146 @@
+package pairtree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+// bruteHash hashes the pairs of tr within [lo, hi) one by one.
+func bruteHash(tr *PairTree, lo, hi pair.Pair) Hash {
+	ph := newPairHasher()
+	var sum Hash
+	tr.Ascend(func(item pair.Pair) bool {
+		if (lo == nilPair || !lessFn(item, lo)) && (hi == nilPair || lessFn(item, hi)) {
+			sum = sum.add(ph.sum(item))
+		}
+		return true
+	})
+	return sum
+}
+
+func TestMerkle(t *testing.T) {
+	if (New(lessFn).RootHash() != Hash{}) {
+		t.Fatal("expected an empty tree to hash to zero")
+	}
+	a := NewWithOptions(Options{Degree: 2, Less: lessFn})
+	b := NewWithOptions(Options{Degree: 5, Less: lessFn})
+	for _, v := range perm(500) {
+		a.ReplaceOrInsert(intVal(PairInt(v), fmt.Sprint(PairInt(v))))
+	}
+	for _, v := range rang(500) {
+		b.ReplaceOrInsert(intVal(PairInt(v), fmt.Sprint(PairInt(v))))
+	}
+	if a.RootHash() != b.RootHash() || a.RootHash() != bruteHash(a, nilPair, nilPair) {
+		t.Fatal("expected trees with the same pairs to hash the same")
+	}
+	b.ReplaceOrInsert(intVal(7, "changed"))
+	if a.RootHash() == b.RootHash() {
+		t.Fatal("expected a changed value to change the hash")
+	}
+
+	// Cached hashes follow writes, including to clones sharing the nodes.
+	rng := rand.New(rand.NewSource(1))
+	clone := a.Clone()
+	for i := 0; i < 2000; i++ {
+		tr := a
+		if i%2 == 1 {
+			tr = clone
+		}
+		v := rng.Intn(600)
+		switch rng.Intn(4) {
+		case 0:
+			tr.ReplaceOrInsert(intVal(v, fmt.Sprint(i)))
+		case 1:
+			tr.Delete(Int(v))
+		case 2:
+			tr.DeleteRange(Int(v), Int(v+rng.Intn(10)))
+		case 3:
+			if c := tr.Cursor(); c.Seek(Int(v)) != nilPair {
+				c.Replace(pair.New(c.Key(), []byte(fmt.Sprint(i))))
+			}
+		}
+		if i%50 == 0 {
+			for _, tr := range []*PairTree{a, clone} {
+				if tr.RootHash() != bruteHash(tr, nilPair, nilPair) {
+					t.Fatalf("step %d: stale root hash", i)
+				}
+				lo, hi := Int(rng.Intn(600)), Int(rng.Intn(600))
+				for _, r := range [][2]pair.Pair{{lo, hi}, {nilPair, hi}, {lo, nilPair}} {
+					if got, want := tr.HashRange(r[0], r[1]), bruteHash(tr, r[0], r[1]); got != want {
+						t.Fatalf("step %d: HashRange(%v, %v) = %x, expected %x",
+							i, IntStr(r[0]), IntStr(r[1]), got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// countingHasher counts the calls to HashRange of the tree it wraps.
+type countingHasher struct {
+	tr    *PairTree
+	calls int
+}
+
+func (c *countingHasher) HashRange(lo, hi pair.Pair) Hash {
+	c.calls++
+	return c.tr.HashRange(lo, hi)
+}
+
+func TestDiffRanges(t *testing.T) {
+	for _, n := range []int{0, 1, 1000} {
+		a := NewWithOptions(Options{Degree: 3, Less: lessFn})
+		b := NewWithOptions(Options{Degree: 4, Less: lessFn})
+		for i := 0; i < n; i++ {
+			a.ReplaceOrInsert(intVal(i*2, "v"))
+			b.ReplaceOrInsert(intVal(i*2, "v"))
+		}
+		// Differences: a pair only in a, a pair only in b, between and
+		// beyond the pairs of a, and a changed value.
+		a.ReplaceOrInsert(Int(-10))
+		b.ReplaceOrInsert(Int(301))
+		b.ReplaceOrInsert(Int(5000))
+		if n > 0 {
+			b.ReplaceOrInsert(intVal(n, "changed"))
+		}
+		cb := &countingHasher{tr: b}
+		var ranges int
+		// Diff a clone of a, so that a can be written to along the way.
+		DiffRanges(a.Clone(), cb, func(lo, hi pair.Pair) bool {
+			ranges++
+			// Copy the range from b to a.
+			a.DeleteRange(lo, hi)
+			b.AscendRange(lo, hi, func(item pair.Pair) bool {
+				a.ReplaceOrInsert(item)
+				return true
+			})
+			return true
+		})
+		if a.RootHash() != b.RootHash() || !intsEqual(treeInts(a), treeInts(b)) {
+			t.Fatalf("n=%d: trees differ after copying the differing ranges", n)
+		}
+		if ranges > 4 || cb.calls > 200 {
+			t.Fatalf("n=%d: %d ranges with %d calls to HashRange", n, ranges, cb.calls)
+		}
+	}
+
+	// Stopping early.
+	a, b := New(lessFn), New(lessFn)
+	for i := 0; i < 100; i++ {
+		b.ReplaceOrInsert(Int(i))
+		if i%10 != 0 {
+			a.ReplaceOrInsert(Int(i))
+		}
+	}
+	var ranges int
+	DiffRanges(a, b, func(lo, hi pair.Pair) bool {
+		ranges++
+		return ranges < 3
+	})
+	if ranges != 3 {
+		t.Fatalf("expected to stop after 3 ranges, got %d", ranges)
+	}
+}
@@ -0,0 +1,152 @@
+package pairtree
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/tidwall/pair"
+)
+
+// VersionedTree is a PairTree that supports any number of concurrent readers
+// alongside a single writer, without readers ever taking a lock.
+//
+// The tree is a sequence of immutable, numbered versions.  A writer calls
+// Begin to start a transaction on a private, lazily copied clone of the latest
+// version, and Commit publishes the clone as the next version with an atomic
+// pointer swap.  Readers call View to load the latest version, which they can
+// keep using for as long as they like, no matter how many versions are
+// committed after it.
+type VersionedTree struct {
+	mu     sync.Mutex // held by the writer for the length of a transaction
+	latest atomic.Pointer[View]
+}
+
+// NewVersioned returns a VersionedTree whose first version, numbered 0, holds
+// the items of tr.  The caller must not use tr directly afterwards.
+func NewVersioned(tr *PairTree) *VersionedTree {
+	vt := &VersionedTree{}
+	vt.latest.Store(&View{tr: tr})
+	return vt
+}
+
+// View returns the latest committed version of the tree.
+func (vt *VersionedTree) View() *View {
+	return vt.latest.Load()
+}
+
+// Begin starts a write transaction on a copy of the latest version.  Only
+// one transaction runs at a time, so Begin blocks until any other transaction
+// is committed or rolled back.
+func (vt *VersionedTree) Begin() *Txn {
+	vt.mu.Lock()
+	base := vt.latest.Load()
+	return &Txn{PairTree: base.tr.detach(), vt: vt, base: base.version}
+}
+
+// View is an immutable, committed version of a VersionedTree.  It is safe
+// for concurrent use by multiple goroutines.
+type View struct {
+	tr      *PairTree
+	version uint64
+}
+
+// Version returns the number of the version, which is incremented by every
+// commit.
+func (v *View) Version() uint64 {
+	return v.version
+}
+
+// Tree returns a PairTree holding the items of the version, for access to
+// the full PairTree API, including cursors.  The tree lazily shares its nodes
+// with the version in O(1) time and is owned by the caller, who may even
+// modify it without affecting the version.
+func (v *View) Tree() *PairTree {
+	return v.tr.detach()
+}
+
+// Len returns the number of items in the version.
+func (v *View) Len() int {
+	return v.tr.Len()
+}
+
+// Get looks for the key item in the version, returning it.  It returns nil if
+// unable to find that item.
+func (v *View) Get(key pair.Pair) pair.Pair {
+	return v.tr.Get(key)
+}
+
+// Has returns true if the given key is in the version.
+func (v *View) Has(key pair.Pair) bool {
+	return v.tr.Has(key)
+}
+
+// Ascend calls the iterator for every value in the version within the range
+// [first, last], until iterator returns false.
+func (v *View) Ascend(iterator func(item pair.Pair) bool) {
+	v.tr.Ascend(iterator)
+}
+
+// AscendRange calls the iterator for every value in the version within the
+// range [greaterOrEqual, lessThan), until iterator returns false.
+func (v *View) AscendRange(greaterOrEqual, lessThan pair.Pair, iterator func(item pair.Pair) bool) {
+	v.tr.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// Descend calls the iterator for every value in the version within the range
+// [last, first], until iterator returns false.
+func (v *View) Descend(iterator func(item pair.Pair) bool) {
+	v.tr.Descend(iterator)
+}
+
+// DescendRange calls the iterator for every value in the version within the
+// range [lessOrEqual, greaterThan), until iterator returns false.
+func (v *View) DescendRange(lessOrEqual, greaterThan pair.Pair, iterator func(item pair.Pair) bool) {
+	v.tr.DescendRange(lessOrEqual, greaterThan, iterator)
+}
+
+// Txn is a write transaction on a VersionedTree.  The embedded PairTree holds
+// the transaction's private copy of the tree, and its changes are not seen by
+// readers until Commit.
+//
+// A Txn must not be used after it is committed or rolled back.
+type Txn struct {
+	*PairTree
+	vt   *VersionedTree
+	base uint64
+}
+
+// Commit publishes the transaction's tree as the latest version, returning
+// its version number, and ends the transaction.
+func (tx *Txn) Commit() uint64 {
+	tr := tx.finish()
+	version := tx.base + 1
+	tx.vt.latest.Store(&View{tr: tr, version: version})
+	tx.vt.mu.Unlock()
+	return version
+}
+
+// Rollback discards the transaction's changes and ends the transaction.
+func (tx *Txn) Rollback() {
+	tx.finish()
+	tx.vt.mu.Unlock()
+}
+
+func (tx *Txn) finish() *PairTree {
+	tr := tx.PairTree
+	if tr == nil {
+		panic("transaction already finished")
+	}
+	tx.PairTree = nil
+	return tr
+}
+
+// detach returns a tree that lazily shares all of the nodes of t, like Clone,
+// but without modifying t, so it is safe to call while other goroutines read
+// t.  The returned tree owns none of the nodes, so writing to it never changes
+// t.  Unlike with Clone, writes to t are seen by the returned tree, so t must
+// not be written to afterwards.
+func (t *PairTree) detach() *PairTree {
+	out := *t
+	out.cow = &copyOnWriteContext{freelist: t.cow.freelist}
+	return &out
+}
@@ -0,0 +1,77 @@
+package pairtree
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+func TestVersionedTree(t *testing.T) {
+	vt := NewVersioned(New(lessFn))
+	if v := vt.View(); v.Version() != 0 || v.Len() != 0 {
+		t.Fatalf("expected empty version 0, got version %d with %d items", v.Version(), v.Len())
+	}
+	const commits = 200
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last uint64
+			for !done.Load() {
+				v := vt.View()
+				if v.Version() < last {
+					t.Errorf("version went back from %d to %d", last, v.Version())
+				}
+				last = v.Version()
+				// Version n holds the items [0, n).
+				n := 0
+				v.Ascend(func(item pair.Pair) bool {
+					if PairInt(item) != n {
+						t.Errorf("version %d: expected %d, got %d", v.Version(), n, PairInt(item))
+					}
+					n++
+					return true
+				})
+				if uint64(n) != v.Version() || v.Len() != n {
+					t.Errorf("version %d has %d items, length %d", v.Version(), n, v.Len())
+				}
+			}
+		}()
+	}
+	for i := 0; i < commits; i++ {
+		tx := vt.Begin()
+		tx.ReplaceOrInsert(Int(i))
+		if i%10 == 0 {
+			// Work that is rolled back is never seen.
+			tx.Rollback()
+			tx = vt.Begin()
+			tx.ReplaceOrInsert(Int(i))
+		}
+		if version := tx.Commit(); version != uint64(i+1) {
+			t.Fatalf("expected version %d, got %d", i+1, version)
+		}
+	}
+	done.Store(true)
+	wg.Wait()
+
+	old := vt.View()
+	tx := vt.Begin()
+	tx.DeleteRange(nilPair, nilPair)
+	tr := old.Tree()
+	tr.ReplaceOrInsert(Int(-1))
+	tx.Commit()
+	if old.Len() != commits || vt.View().Len() != 0 {
+		t.Fatalf("expected an old version of %d items and an empty latest version", commits)
+	}
+	checkTree(t, tr)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic committing twice")
+		}
+	}()
+	tx.Commit()
+}
@@ -15,7 +15,9 @@
 // Package btree implements in-memory B-Trees of arbitrary degree.
 //
 // btree implements an in-memory B-Tree for use as an ordered data structure.
-// It is not meant for persistent storage solutions.
+// It is not meant for persistent storage solutions, although a snapshot of a
+// tree can be saved and restored with WriteTo and ReadFrom, or written with
+// WriteMapped and opened read-only in place with OpenMapped.
 //
 // It has a flatter structure than an equivalent red-black or other binary tree,
 // which in some cases yields better memory usage and/or performance.
@@ -49,10 +51,12 @@ package pairtree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/tidwall/pair"
 )
@@ -62,79 +66,219 @@ const (
 	defaultDegrees      = 9
 )
 
-var (
-	nilPairs    = make(items, 16)
-	nilChildren = make(children, 16)
-)
+// freeList represents a free list of btree nodes.
+type freeList[T any] struct {
+	mu       sync.Mutex
+	freelist []*nodeG[T]
+	hits     uint64
+	misses   uint64
+}
 
-// freeList represents a free list of btree nodes. By default each
-// BTree has its own freeList, but multiple BTrees can share the same
-// freeList.
+// FreeList represents a free list of btree nodes. By default each
+// BTree has its own FreeList, but multiple BTrees can share the same
+// FreeList.
 // Two Btrees using the same freelist are safe for concurrent write access.
-type freeList struct {
-	mu       sync.Mutex
-	freelist []*node
+type FreeList freeList[pair.Pair]
+
+// FreeListStats contains usage statistics for a FreeList.
+type FreeListStats struct {
+	Hits   uint64 // number of nodes reused from the free list
+	Misses uint64 // number of nodes allocated because the free list was empty
+	Size   int    // number of nodes currently held by the free list
+}
+
+// NewFreeList creates a new free list.
+// size is the maximum size of the returned free list.
+func NewFreeList(size int) *FreeList {
+	return (*FreeList)(newFreeList[pair.Pair](size))
 }
 
 // newFreeList creates a new free list.
 // size is the maximum size of the returned free list.
-func newFreeList(size int) *freeList {
-	return &freeList{freelist: make([]*node, 0, size)}
+func newFreeList[T any](size int) *freeList[T] {
+	return &freeList[T]{freelist: make([]*nodeG[T], 0, size)}
 }
 
-func (f *freeList) newNode() (n *node) {
+func (f *freeList[T]) newNode() (n *nodeG[T]) {
 	f.mu.Lock()
 	index := len(f.freelist) - 1
 	if index < 0 {
+		f.misses++
 		f.mu.Unlock()
-		return new(node)
+		return new(nodeG[T])
 	}
 	n = f.freelist[index]
 	f.freelist[index] = nil
 	f.freelist = f.freelist[:index]
+	f.hits++
 	f.mu.Unlock()
 	return
 }
 
-func (f *freeList) freeNode(n *node) {
+// freeNode adds the given node to the list, returning true if it was added
+// and false if it was discarded.
+func (f *freeList[T]) freeNode(n *nodeG[T]) (out bool) {
 	f.mu.Lock()
 	if len(f.freelist) < cap(f.freelist) {
 		f.freelist = append(f.freelist, n)
+		out = true
 	}
 	f.mu.Unlock()
+	return
+}
+
+func (f *freeList[T]) stats() FreeListStats {
+	f.mu.Lock()
+	stats := FreeListStats{Hits: f.hits, Misses: f.misses, Size: len(f.freelist)}
+	f.mu.Unlock()
+	return stats
 }
 
-// New creates a new B-Tree with the given degree.
+// Stats returns the usage statistics of the free list.
+func (f *FreeList) Stats() FreeListStats {
+	return (*freeList[pair.Pair])(f).stats()
+}
+
+// New creates a new B-Tree with the default degree and free list size.
 //
-// New(2), for example, will create a 2-3-4 tree (each node contains 1-3 items
-// and 2-4 children).
+// A nil less function orders pairs by comparing their keys with
+// bytes.Compare.
 func New(less func(a, b pair.Pair) bool) *PairTree {
-	return newWithFreeList(newFreeList(defaultFreeListSize), less)
+	return NewWithFreeList(NewFreeList(defaultFreeListSize), less)
 }
 
-// newWithFreeList creates a new B-Tree that uses the given node free list.
-func newWithFreeList(f *freeList, less func(a, b pair.Pair) bool) *PairTree {
-	if less == nil {
-		less = func(a, b pair.Pair) bool {
-			return bytes.Compare(a.Key(), b.Key()) == -1
+// NewWithFreeList creates a new B-Tree with the default degree that uses the
+// given node free list.
+func NewWithFreeList(f *FreeList, less func(a, b pair.Pair) bool) *PairTree {
+	return newWithFreeList(defaultDegrees, (*freeList[pair.Pair])(f), orderBy(less, nil))
+}
+
+// NewWithCompare creates a new B-Tree with the default degree and free list
+// size, whose pairs are ordered by compare.  compare returns a negative number
+// when a < b, zero when a == b and a positive number when a > b.
+//
+// Searches use compare directly, detecting an equal pair with a single call,
+// so this needs about half as many calls as a tree created with New when
+// comparing is expensive.  A nil compare function compares keys with
+// bytes.Compare.
+func NewWithCompare(compare func(a, b pair.Pair) int) *PairTree {
+	return newWithFreeList(defaultDegrees, newFreeList[pair.Pair](defaultFreeListSize), orderBy(nil, compare))
+}
+
+// Options are used to configure a B-Tree created by NewWithOptions.
+type Options struct {
+	// Degree is the degree of the B-Tree. Each node, except for the root,
+	// contains between Degree-1 and Degree*2-1 items. A degree of 2, for
+	// example, will create a 2-3-4 tree (each node contains 1-3 items and
+	// 2-4 children). Zero uses the default degree of 9.
+	Degree int
+	// FreeListSize is the maximum number of nodes kept on the free list for
+	// reuse. Zero uses the default size of 32. It is ignored when FreeList is
+	// set.
+	FreeListSize int
+	// FreeList is a free list shared with other trees. A nil FreeList creates
+	// a new one for this tree.
+	FreeList *FreeList
+	// Less orders the pairs in the tree. It is ignored when Compare is set.
+	// When both are nil, keys are compared with bytes.Compare.
+	Less func(a, b pair.Pair) bool
+	// Compare orders the pairs in the tree, returning a negative number when
+	// a < b, zero when a == b and a positive number when a > b. See
+	// NewWithCompare.
+	Compare func(a, b pair.Pair) int
+}
+
+// NewWithOptions creates a new B-Tree configured with the given options.
+//
+// It panics if Degree is not zero and is less than 2, or if FreeList is nil
+// and FreeListSize is negative.
+func NewWithOptions(opts Options) *PairTree {
+	degree := opts.Degree
+	if degree == 0 {
+		degree = defaultDegrees
+	} else if degree < 2 {
+		panic("bad degree")
+	}
+	f := (*freeList[pair.Pair])(opts.FreeList)
+	if f == nil {
+		size := opts.FreeListSize
+		if size == 0 {
+			size = defaultFreeListSize
+		} else if size < 0 {
+			panic("bad free list size")
 		}
+		f = newFreeList[pair.Pair](size)
+	}
+	return newWithFreeList(degree, f, orderBy(opts.Less, opts.Compare))
+}
+
+// newWithFreeList creates a new B-Tree that uses the given node free list.
+func newWithFreeList(degree int, f *freeList[pair.Pair], ord ordering[pair.Pair]) *PairTree {
+	return &PairTree{btree[pair.Pair]{
+		degree:   degree,
+		cow:      &copyOnWriteContext{freelist: f},
+		ordering: ord,
+	}}
+}
+
+// orderBy returns the ordering for a tree created with the given less and
+// compare functions, preferring compare and defaulting to compareKeys.
+func orderBy(less func(a, b pair.Pair) bool, compare func(a, b pair.Pair) int) ordering[pair.Pair] {
+	if compare == nil && less == nil {
+		compare = compareKeys
 	}
-	return &PairTree{
-		degree: defaultDegrees,
-		cow:    &copyOnWriteContext{freelist: f},
-		less:   less,
+	if compare != nil {
+		return ordering[pair.Pair]{less: lessOf(compare), cmp: compare}
 	}
+	return ordering[pair.Pair]{less: less}
+}
+
+// compareKeys orders pairs by comparing their keys with bytes.Compare.
+func compareKeys(a, b pair.Pair) int {
+	return bytes.Compare(a.Key(), b.Key())
 }
 
 var nilPair = pair.Pair{}
 
+// The node types used by PairTree.
+type (
+	node               = nodeG[pair.Pair]
+	items              = itemsG[pair.Pair]
+	children           = childrenG[pair.Pair]
+	copyOnWriteContext = copyOnWriteContextG[pair.Pair]
+)
+
+// optionalItem is an item that may or may not be set, used for the bounds of
+// an iteration.
+type optionalItem[T any] struct {
+	item  T
+	valid bool
+}
+
+func optional[T any](item T) optionalItem[T] {
+	return optionalItem[T]{item: item, valid: true}
+}
+
+func empty[T any]() optionalItem[T] {
+	return optionalItem[T]{}
+}
+
+// bound returns an iteration bound for a pair, treating nil as unbounded.
+func bound(item pair.Pair) optionalItem[pair.Pair] {
+	if item == nilPair {
+		return empty[pair.Pair]()
+	}
+	return optional(item)
+}
+
 // items stores items in a node.
-type items []pair.Pair
+type itemsG[T any] []T
 
 // insertAt inserts a value into the given index, pushing all subsequent values
 // forward.
-func (s *items) insertAt(index int, item pair.Pair) {
-	*s = append(*s, nilPair)
+func (s *itemsG[T]) insertAt(index int, item T) {
+	var zero T
+	*s = append(*s, zero)
 	if index < len(*s) {
 		copy((*s)[index+1:], (*s)[index:])
 	}
@@ -143,58 +287,76 @@ func (s *items) insertAt(index int, item pair.Pair) {
 
 // removeAt removes a value at a given index, pulling all subsequent values
 // back.
-func (s *items) removeAt(index int) pair.Pair {
+func (s *itemsG[T]) removeAt(index int) T {
+	var zero T
 	item := (*s)[index]
 	copy((*s)[index:], (*s)[index+1:])
-	(*s)[len(*s)-1] = nilPair
+	(*s)[len(*s)-1] = zero
 	*s = (*s)[:len(*s)-1]
 	return item
 }
 
 // pop removes and returns the last element in the list.
-func (s *items) pop() (out pair.Pair) {
+func (s *itemsG[T]) pop() (out T) {
+	var zero T
 	index := len(*s) - 1
 	out = (*s)[index]
-	(*s)[index] = nilPair
+	(*s)[index] = zero
 	*s = (*s)[:index]
 	return
 }
 
 // truncate truncates this instance at index so that it contains only the
 // first index items. index must be less than or equal to length.
-func (s *items) truncate(index int) {
-	var toClear items
-	*s, toClear = (*s)[:index], (*s)[index:]
-	for len(toClear) > 0 {
-		toClear = toClear[copy(toClear, nilPairs):]
+func (s *itemsG[T]) truncate(index int) {
+	var zero T
+	toClear := (*s)[index:]
+	*s = (*s)[:index]
+	for i := range toClear {
+		toClear[i] = zero
 	}
 }
 
 // find returns the index where the given item should be inserted into this
 // list.  'found' is true if the item already exists in the list at the given
 // index.
-func (s items) find(item pair.Pair, less func(a, b pair.Pair) bool) (index int, found bool) {
+func (s itemsG[T]) find(item T, o *ordering[T]) (index int, found bool) {
+	if o.cmp != nil {
+		i, j := 0, len(s)
+		for i < j {
+			h := i + (j-i)/2
+			c := o.cmp(item, s[h])
+			if c == 0 {
+				return h, true
+			} else if c > 0 {
+				i = h + 1
+			} else {
+				j = h
+			}
+		}
+		return i, false
+	}
 	i, j := 0, len(s)
 	for i < j {
 		h := i + (j-i)/2
-		if !less(item, s[h]) {
+		if !o.less(item, s[h]) {
 			i = h + 1
 		} else {
 			j = h
 		}
 	}
-	if i > 0 && !less(s[i-1], item) {
+	if i > 0 && !o.less(s[i-1], item) {
 		return i - 1, true
 	}
 	return i, false
 }
 
 // children stores child nodes in a node.
-type children []*node
+type childrenG[T any] []*nodeG[T]
 
 // insertAt inserts a value into the given index, pushing all subsequent values
 // forward.
-func (s *children) insertAt(index int, n *node) {
+func (s *childrenG[T]) insertAt(index int, n *nodeG[T]) {
 	*s = append(*s, nil)
 	if index < len(*s) {
 		copy((*s)[index+1:], (*s)[index:])
@@ -204,7 +366,7 @@ func (s *children) insertAt(index int, n *node) {
 
 // removeAt removes a value at a given index, pulling all subsequent values
 // back.
-func (s *children) removeAt(index int) *node {
+func (s *childrenG[T]) removeAt(index int) *nodeG[T] {
 	n := (*s)[index]
 	copy((*s)[index:], (*s)[index+1:])
 	(*s)[len(*s)-1] = nil
@@ -213,7 +375,7 @@ func (s *children) removeAt(index int) *node {
 }
 
 // pop removes and returns the last element in the list.
-func (s *children) pop() (out *node) {
+func (s *childrenG[T]) pop() (out *nodeG[T]) {
 	index := len(*s) - 1
 	out = (*s)[index]
 	(*s)[index] = nil
@@ -223,11 +385,11 @@ func (s *children) pop() (out *node) {
 
 // truncate truncates this instance at index so that it contains only the
 // first index children. index must be less than or equal to length.
-func (s *children) truncate(index int) {
-	var toClear children
-	*s, toClear = (*s)[:index], (*s)[index:]
-	for len(toClear) > 0 {
-		toClear = toClear[copy(toClear, nilChildren):]
+func (s *childrenG[T]) truncate(index int) {
+	toClear := (*s)[index:]
+	*s = (*s)[:index]
+	for i := range toClear {
+		toClear[i] = nil
 	}
 }
 
@@ -236,34 +398,44 @@ func (s *children) truncate(index int) {
 // It must at all times maintain the invariant that either
 //   * len(children) == 0, len(items) unconstrained
 //   * len(children) == len(items) + 1
-type node struct {
-	items    items
-	children children
-	cow      *copyOnWriteContext
+type nodeG[T any] struct {
+	items    itemsG[T]
+	children childrenG[T]
+	count    int // number of items in the subtree rooted at this node
+	cow      *copyOnWriteContextG[T]
+	id       uint64               // set once written by a Checkpointer, zero until then
+	hash     atomic.Pointer[Hash] // cached by nodeHash, nil until then
 }
 
-func (n *node) mutableFor(cow *copyOnWriteContext) *node {
+func (n *nodeG[T]) mutableFor(cow *copyOnWriteContextG[T]) *nodeG[T] {
 	if n.cow == cow {
+		// The node is about to be modified, so its cached hash is stale.
+		// Most trees are never hashed, so the store is skipped when there
+		// is nothing to discard.
+		if n.hash.Load() != nil {
+			n.hash.Store(nil)
+		}
 		return n
 	}
 	out := cow.newNode()
 	if cap(out.items) >= len(n.items) {
 		out.items = out.items[:len(n.items)]
 	} else {
-		out.items = make(items, len(n.items), cap(n.items))
+		out.items = make(itemsG[T], len(n.items), cap(n.items))
 	}
 	copy(out.items, n.items)
 	// Copy children
 	if cap(out.children) >= len(n.children) {
 		out.children = out.children[:len(n.children)]
 	} else {
-		out.children = make(children, len(n.children), cap(n.children))
+		out.children = make(childrenG[T], len(n.children), cap(n.children))
 	}
 	copy(out.children, n.children)
+	out.count = n.count
 	return out
 }
 
-func (n *node) mutableChild(i int) *node {
+func (n *nodeG[T]) mutableChild(i int) *nodeG[T] {
 	c := n.children[i].mutableFor(n.cow)
 	n.children[i] = c
 	return c
@@ -272,21 +444,26 @@ func (n *node) mutableChild(i int) *node {
 // split splits the given node at the given index.  The current node shrinks,
 // and this function returns the item that existed at that index and a new node
 // containing all items/children after it.
-func (n *node) split(i int) (pair.Pair, *node) {
+func (n *nodeG[T]) split(i int) (T, *nodeG[T]) {
 	item := n.items[i]
 	next := n.cow.newNode()
 	next.items = append(next.items, n.items[i+1:]...)
 	n.items.truncate(i)
+	next.count = len(next.items)
 	if len(n.children) > 0 {
 		next.children = append(next.children, n.children[i+1:]...)
 		n.children.truncate(i + 1)
+		for _, c := range next.children {
+			next.count += c.count
+		}
 	}
+	n.count -= next.count + 1
 	return item, next
 }
 
 // maybeSplitChild checks if a child should be split, and if so splits it.
 // Returns whether or not a split occurred.
-func (n *node) maybeSplitChild(i, maxPairs int) bool {
+func (n *nodeG[T]) maybeSplitChild(i, maxPairs int) bool {
 	if len(n.children[i].items) < maxPairs {
 		return false
 	}
@@ -300,70 +477,143 @@ func (n *node) maybeSplitChild(i, maxPairs int) bool {
 // insert inserts an item into the subtree rooted at this node, making sure
 // no nodes in the subtree exceed maxPairs items.  Should an equivalent item be
 // be found/replaced by insert, it will be returned.
-func (n *node) insert(item pair.Pair, maxPairs int, less func(a, b pair.Pair) bool) pair.Pair {
-	i, found := n.items.find(item, less)
+func (n *nodeG[T]) insert(item T, maxPairs int, o *ordering[T]) (_ T, _ bool) {
+	i, found := n.items.find(item, o)
 	if found {
 		out := n.items[i]
 		n.items[i] = item
-		return out
+		return out, true
 	}
 	if len(n.children) == 0 {
 		n.items.insertAt(i, item)
-		return nilPair
+		n.count++
+		return
 	}
 	if n.maybeSplitChild(i, maxPairs) {
 		inTree := n.items[i]
-		switch {
-		case less(item, inTree):
+		switch c := o.compare(item, inTree); {
+		case c < 0:
 			// no change, we want first split node
-		case less(inTree, item):
+		case c > 0:
 			i++ // we want second split node
 		default:
 			out := n.items[i]
 			n.items[i] = item
-			return out
+			return out, true
 		}
 	}
-	return n.mutableChild(i).insert(item, maxPairs, less)
+	out, replaced := n.mutableChild(i).insert(item, maxPairs, o)
+	if !replaced {
+		n.count++
+	}
+	return out, replaced
 }
 
 // get finds the given key in the subtree and returns it.
-func (n *node) get(key pair.Pair, less func(a, b pair.Pair) bool) pair.Pair {
-	i, found := n.items.find(key, less)
+func (n *nodeG[T]) get(key T, o *ordering[T]) (_ T, _ bool) {
+	i, found := n.items.find(key, o)
 	if found {
-		return n.items[i]
+		return n.items[i], true
 	} else if len(n.children) > 0 {
-		return n.children[i].get(key, less)
+		return n.children[i].get(key, o)
+	}
+	return
+}
+
+// nearest descends the subtree toward key, just like get, and returns the
+// closest item to key in the given direction: the greatest item less than key
+// when descending, or the least item greater than key when ascending.  If
+// inclusive is true, an item equal to key is returned when found.
+func (n *nodeG[T]) nearest(key T, dir direction, inclusive bool, o *ordering[T]) (out T, ok bool) {
+	for {
+		i, found := n.items.find(key, o)
+		if found && inclusive {
+			return n.items[i], true
+		}
+		// Items before index i are less than key, and any items in child i
+		// are between the items surrounding it.
+		switch dir {
+		case descend:
+			if i > 0 {
+				out, ok = n.items[i-1], true
+			}
+		case ascend:
+			if found {
+				i++
+			}
+			if i < len(n.items) {
+				out, ok = n.items[i], true
+			}
+		}
+		if len(n.children) == 0 {
+			return out, ok
+		}
+		n = n.children[i]
+	}
+}
+
+// getAt returns the item at index i of the subtree, which must be within
+// range.
+func (n *nodeG[T]) getAt(i int) T {
+	for len(n.children) > 0 {
+		j := 0
+		for ; i >= n.children[j].count; j++ {
+			i -= n.children[j].count
+			if i == 0 {
+				return n.items[j]
+			}
+			i--
+		}
+		n = n.children[j]
+	}
+	return n.items[i]
+}
+
+// rank returns the number of items in the subtree that are less than key.
+func (n *nodeG[T]) rank(key T, o *ordering[T]) int {
+	var r int
+	for {
+		i, found := n.items.find(key, o)
+		r += i
+		if len(n.children) == 0 {
+			return r
+		}
+		for _, c := range n.children[:i] {
+			r += c.count
+		}
+		if found {
+			return r + n.children[i].count
+		}
+		n = n.children[i]
 	}
-	return nilPair
 }
 
 // min returns the first item in the subtree.
-func min(n *node) pair.Pair {
+func min[T any](n *nodeG[T]) (_ T, _ bool) {
 	if n == nil {
-		return nilPair
+		return
 	}
 	for len(n.children) > 0 {
 		n = n.children[0]
 	}
 	if len(n.items) == 0 {
-		return nilPair
+		return
 	}
-	return n.items[0]
+	return n.items[0], true
 }
 
 // max returns the last item in the subtree.
-func max(n *node) pair.Pair {
+func max[T any](n *nodeG[T]) (_ T, _ bool) {
 	if n == nil {
-		return nilPair
+		return
 	}
 	for len(n.children) > 0 {
 		n = n.children[len(n.children)-1]
 	}
 	if len(n.items) == 0 {
-		return nilPair
+		return
 	}
-	return n.items[len(n.items)-1]
+	return n.items[len(n.items)-1], true
 }
 
 // toRemove details what item to remove in a node.remove call.
@@ -376,34 +626,37 @@ const (
 )
 
 // remove removes an item from the subtree rooted at this node.
-func (n *node) remove(item pair.Pair, minPairs int, typ toRemove, less func(a, b pair.Pair) bool) pair.Pair {
+func (n *nodeG[T]) remove(item T, minPairs int, typ toRemove, o *ordering[T]) (_ T, _ bool) {
 	var i int
 	var found bool
 	switch typ {
 	case removeMax:
 		if len(n.children) == 0 {
-			return n.items.pop()
+			n.count--
+			return n.items.pop(), true
 		}
 		i = len(n.items)
 	case removeMin:
 		if len(n.children) == 0 {
-			return n.items.removeAt(0)
+			n.count--
+			return n.items.removeAt(0), true
 		}
 		i = 0
 	case removePair:
-		i, found = n.items.find(item, less)
+		i, found = n.items.find(item, o)
 		if len(n.children) == 0 {
 			if found {
-				return n.items.removeAt(i)
+				n.count--
+				return n.items.removeAt(i), true
 			}
-			return nilPair
+			return
 		}
 	default:
 		panic("invalid type")
 	}
 	// If we get to here, we have children.
 	if len(n.children[i].items) <= minPairs {
-		return n.growChildAndRemove(i, item, minPairs, typ, less)
+		return n.growChildAndRemove(i, item, minPairs, typ, o)
 	}
 	child := n.mutableChild(i)
 	// Either we had enough items to begin with, or we've done some
@@ -416,12 +669,18 @@ func (n *node) remove(item pair.Pair, minPairs int, typ toRemove, less func(a, b
 		// We use our special-case 'remove' call with typ=maxPair to pull the
 		// predecessor of item i (the rightmost leaf of our immediate left child)
 		// and set it into where we pulled the item from.
-		n.items[i] = child.remove(nilPair, minPairs, removeMax, less)
-		return out
+		var zero T
+		n.items[i], _ = child.remove(zero, minPairs, removeMax, o)
+		n.count--
+		return out, true
 	}
 	// Final recursive call.  Once we're here, we know that the item isn't in this
 	// node and that the child is big enough to remove from.
-	return child.remove(item, minPairs, typ, less)
+	out, removed := child.remove(item, minPairs, typ, o)
+	if removed {
+		n.count--
+	}
+	return out, removed
 }
 
 // growChildAndRemove grows child 'i' to make sure it's possible to remove an
@@ -443,7 +702,7 @@ func (n *node) remove(item pair.Pair, minPairs int, typ toRemove, less func(a, b
 // We then simply redo our remove call, and the second time (regardless of
 // whether we're in case 1 or 2), we'll have enough items and can guarantee
 // that we hit case A.
-func (n *node) growChildAndRemove(i int, item pair.Pair, minPairs int, typ toRemove, less func(a, b pair.Pair) bool) pair.Pair {
+func (n *nodeG[T]) growChildAndRemove(i int, item T, minPairs int, typ toRemove, o *ordering[T]) (T, bool) {
 	if i > 0 && len(n.children[i-1].items) > minPairs {
 		// Steal from left child
 		child := n.mutableChild(i)
@@ -451,8 +710,13 @@ func (n *node) growChildAndRemove(i int, item pair.Pair, minPairs int, typ toRem
 		stolenPair := stealFrom.items.pop()
 		child.items.insertAt(0, n.items[i-1])
 		n.items[i-1] = stolenPair
+		child.count++
+		stealFrom.count--
 		if len(stealFrom.children) > 0 {
-			child.children.insertAt(0, stealFrom.children.pop())
+			stolen := stealFrom.children.pop()
+			child.children.insertAt(0, stolen)
+			child.count += stolen.count
+			stealFrom.count -= stolen.count
 		}
 	} else if i < len(n.items) && len(n.children[i+1].items) > minPairs {
 		// steal from right child
@@ -461,8 +725,13 @@ func (n *node) growChildAndRemove(i int, item pair.Pair, minPairs int, typ toRem
 		stolenPair := stealFrom.items.removeAt(0)
 		child.items = append(child.items, n.items[i])
 		n.items[i] = stolenPair
+		child.count++
+		stealFrom.count--
 		if len(stealFrom.children) > 0 {
-			child.children = append(child.children, stealFrom.children.removeAt(0))
+			stolen := stealFrom.children.removeAt(0)
+			child.children = append(child.children, stolen)
+			child.count += stolen.count
+			stealFrom.count -= stolen.count
 		}
 	} else {
 		if i >= len(n.items) {
@@ -475,9 +744,10 @@ func (n *node) growChildAndRemove(i int, item pair.Pair, minPairs int, typ toRem
 		child.items = append(child.items, mergePair)
 		child.items = append(child.items, mergeChild.items...)
 		child.children = append(child.children, mergeChild.children...)
+		child.count += mergeChild.count + 1
 		n.cow.freeNode(mergeChild)
 	}
-	return n.remove(item, minPairs, typ, less)
+	return n.remove(item, minPairs, typ, o)
 }
 
 type direction int
@@ -494,25 +764,25 @@ const (
 // will force the iterator to include the first item when it equals 'start',
 // thus creating a "greaterOrEqual" or "lessThanEqual" rather than just a
 // "greaterThan" or "lessThan" queries.
-func (n *node) iterate(dir direction, start, stop pair.Pair, includeStart bool, hit bool, iter func(item pair.Pair) bool, less func(a, b pair.Pair) bool) (bool, bool) {
+func (n *nodeG[T]) iterate(dir direction, start, stop optionalItem[T], includeStart bool, hit bool, iter func(item T) bool, o *ordering[T]) (bool, bool) {
 	var ok bool
 	switch dir {
 	case ascend:
 		for i := 0; i < len(n.items); i++ {
-			if start != nilPair && less(n.items[i], start) {
+			if start.valid && o.less(n.items[i], start.item) {
 				continue
 			}
 			if len(n.children) > 0 {
-				if hit, ok = n.children[i].iterate(dir, start, stop, includeStart, hit, iter, less); !ok {
+				if hit, ok = n.children[i].iterate(dir, start, stop, includeStart, hit, iter, o); !ok {
 					return hit, false
 				}
 			}
-			if !includeStart && !hit && start != nilPair && !less(start, n.items[i]) {
+			if !includeStart && !hit && start.valid && !o.less(start.item, n.items[i]) {
 				hit = true
 				continue
 			}
 			hit = true
-			if stop != nilPair && !less(n.items[i], stop) {
+			if stop.valid && !o.less(n.items[i], stop.item) {
 				return hit, false
 			}
 			if !iter(n.items[i]) {
@@ -520,23 +790,23 @@ func (n *node) iterate(dir direction, start, stop pair.Pair, includeStart bool,
 			}
 		}
 		if len(n.children) > 0 {
-			if hit, ok = n.children[len(n.children)-1].iterate(dir, start, stop, includeStart, hit, iter, less); !ok {
+			if hit, ok = n.children[len(n.children)-1].iterate(dir, start, stop, includeStart, hit, iter, o); !ok {
 				return hit, false
 			}
 		}
 	case descend:
 		for i := len(n.items) - 1; i >= 0; i-- {
-			if start != nilPair && !less(n.items[i], start) {
-				if !includeStart || hit || less(start, n.items[i]) {
+			if start.valid && !o.less(n.items[i], start.item) {
+				if !includeStart || hit || o.less(start.item, n.items[i]) {
 					continue
 				}
 			}
 			if len(n.children) > 0 {
-				if hit, ok = n.children[i+1].iterate(dir, start, stop, includeStart, hit, iter, less); !ok {
+				if hit, ok = n.children[i+1].iterate(dir, start, stop, includeStart, hit, iter, o); !ok {
 					return hit, false
 				}
 			}
-			if stop != nilPair && !less(stop, n.items[i]) {
+			if stop.valid && !o.less(stop.item, n.items[i]) {
 				return hit, false //	continue
 			}
 			hit = true
@@ -545,7 +815,7 @@ func (n *node) iterate(dir direction, start, stop pair.Pair, includeStart bool,
 			}
 		}
 		if len(n.children) > 0 {
-			if hit, ok = n.children[0].iterate(dir, start, stop, includeStart, hit, iter, less); !ok {
+			if hit, ok = n.children[0].iterate(dir, start, stop, includeStart, hit, iter, o); !ok {
 				return hit, false
 			}
 		}
@@ -554,13 +824,53 @@ func (n *node) iterate(dir direction, start, stop pair.Pair, includeStart bool,
 }
 
 // Used for testing/debugging purposes.
-func (n *node) print(w io.Writer, level int) {
+func (n *nodeG[T]) print(w io.Writer, level int) {
 	fmt.Fprintf(w, "%sNODE:%v\n", strings.Repeat("  ", level), n.items)
 	for _, c := range n.children {
 		c.print(w, level+1)
 	}
 }
 
+// btree is the generic B-Tree implementation shared by PairTree and TreeG.
+type btree[T any] struct {
+	degree int
+	length int
+	root   *nodeG[T]
+	cow    *copyOnWriteContextG[T]
+	mods   uint64 // number of times the tree has been modified
+	ordering[T]
+}
+
+// ordering holds the functions used to order the items of a tree.  less is
+// always set, while cmp is only set when the tree was created with a
+// three-way comparator, allowing searches to detect an equal item with a
+// single call rather than two calls to less.
+type ordering[T any] struct {
+	less func(a, b T) bool
+	cmp  func(a, b T) int
+}
+
+// compare returns a negative number when a < b, zero when a == b and a
+// positive number when a > b.
+func (o *ordering[T]) compare(a, b T) int {
+	if o.cmp != nil {
+		return o.cmp(a, b)
+	}
+	if o.less(a, b) {
+		return -1
+	} else if o.less(b, a) {
+		return 1
+	}
+	return 0
+}
+
+// lessOf returns a less function that calls cmp.
+func lessOf[T any](cmp func(a, b T) int) func(a, b T) bool {
+	return func(a, b T) bool {
+		return cmp(a, b) < 0
+	}
+}
+
 // PairTree is an implementation of a B-Tree.
 //
 // PairTree stores Pair instances in an ordered structure, allowing easy insertion,
@@ -569,14 +879,10 @@ func (n *node) print(w io.Writer, level int) {
 // Write operations are not safe for concurrent mutation by multiple
 // goroutines, but Read operations are.
 type PairTree struct {
-	degree int
-	length int
-	root   *node
-	less   func(a, b pair.Pair) bool
-	cow    *copyOnWriteContext
+	btree[pair.Pair]
 }
 
-// copyOnWriteContext pointers determine node ownership... a tree with a write
+// copyOnWriteContextG pointers determine node ownership... a tree with a write
 // context equivalent to a node's write context is allowed to modify that node.
 // A tree whose write context does not match a node's is not allowed to modify
 // it, and must create a new, writable copy (IE: it's a Clone).
@@ -590,8 +896,8 @@ type PairTree struct {
 // tree's context, that node is modifiable in place.  Children of that node may
 // not share context, but before we descend into them, we'll make a mutable
 // copy.
-type copyOnWriteContext struct {
-	freelist *freeList
+type copyOnWriteContextG[T any] struct {
+	freelist *freeList[T]
 }
 
 // Clone clones the btree, lazily.  Clone should not be called concurrently,
@@ -606,6 +912,10 @@ type copyOnWriteContext struct {
 // copies due to the aforementioned copy-on-write logic, but should converge to
 // the original performance characteristics of the original tree.
 func (t *PairTree) Clone() (t2 *PairTree) {
+	return &PairTree{t.clone()}
+}
+
+func (t *btree[T]) clone() btree[T] {
 	// Create two entirely new copy-on-write contexts.
 	// This operation effectively creates three trees:
 	//   the original, shared nodes (old b.cow)
@@ -615,34 +925,94 @@ func (t *PairTree) Clone() (t2 *PairTree) {
 	out := *t
 	t.cow = &cow1
 	out.cow = &cow2
-	return &out
+	return out
+}
+
+// Clear removes all items from the tree.  If addNodesToFreelist is true, the
+// tree's nodes are added to its free list as part of this call, until the free
+// list is full.  Otherwise, the root node is simply dereferenced and the
+// subtree left to Go's normal GC processes.
+//
+// Only nodes owned by the tree's copy-on-write context are reclaimed.  Nodes
+// shared with clones are left untouched and are not descended into, since
+// nothing beneath a shared node can be owned by this tree.
+//
+// This can be much faster than calling Delete on all elements, and somewhat
+// faster than creating a new tree to replace the old one, because nodes from
+// the old tree are reclaimed into the free list for use by the new one,
+// instead of being lost to the garbage collector.
+func (t *PairTree) Clear(addNodesToFreelist bool) {
+	t.clear(addNodesToFreelist)
+}
+
+func (t *btree[T]) clear(addNodesToFreelist bool) {
+	if t.root != nil && addNodesToFreelist {
+		t.root.reset(t.cow)
+	}
+	t.root, t.length = nil, 0
+	t.mods++
+}
+
+// reset returns a subtree to the freelist.  It breaks out immediately if the
+// freelist is full, since the only benefit of iterating is to fill that
+// freelist up.  Returns true if parent reset call should continue.
+func (n *nodeG[T]) reset(c *copyOnWriteContextG[T]) bool {
+	if n.cow != c {
+		return true
+	}
+	for _, child := range n.children {
+		if !child.reset(c) {
+			return false
+		}
+	}
+	return c.freeNode(n) != ftFreelistFull
 }
 
 // maxPairs returns the max number of items to allow per node.
-func (t *PairTree) maxPairs() int {
+func (t *btree[T]) maxPairs() int {
 	return t.degree*2 - 1
 }
 
 // minPairs returns the min number of items to allow per node (ignored for the
 // root node).
-func (t *PairTree) minPairs() int {
+func (t *btree[T]) minPairs() int {
 	return t.degree - 1
 }
 
-func (c *copyOnWriteContext) newNode() (n *node) {
+func (c *copyOnWriteContextG[T]) newNode() (n *nodeG[T]) {
 	n = c.freelist.newNode()
 	n.cow = c
 	return
 }
 
-func (c *copyOnWriteContext) freeNode(n *node) {
+type freeType int
+
+const (
+	ftFreelistFull freeType = iota // node was freed (available for GC, not stored in freelist)
+	ftStored                       // node was stored in the freelist for later use
+	ftNotOwned                     // node was ignored by COW, since it's owned by another one
+)
+
+// freeNode frees a node within a given COW context, if it's owned by that
+// context.  It returns what happened to the node (see freeType const
+// documentation).
+func (c *copyOnWriteContextG[T]) freeNode(n *nodeG[T]) freeType {
 	if n.cow == c {
 		// clear to allow GC
 		n.items.truncate(0)
 		n.children.truncate(0)
+		n.count = 0
 		n.cow = nil
-		c.freelist.freeNode(n)
+		n.id = 0
+		if n.hash.Load() != nil {
+			n.hash.Store(nil)
+		}
+		if c.freelist.freeNode(n) {
+			return ftStored
+		}
+		return ftFreelistFull
 	}
+	return ftNotOwned
 }
 
 // ReplaceOrInsert adds the given item to the tree.  If an item in the tree
@@ -654,11 +1024,18 @@ func (t *PairTree) ReplaceOrInsert(item pair.Pair) pair.Pair {
 	if item == nilPair {
 		panic("nil item being added to BTree")
 	}
+	out, _ := t.replaceOrInsert(item)
+	return out
+}
+
+func (t *btree[T]) replaceOrInsert(item T) (_ T, _ bool) {
+	t.mods++
 	if t.root == nil {
 		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, item)
+		t.root.count = 1
 		t.length++
-		return nilPair
+		return
 	} else {
 		t.root = t.root.mutableFor(t.cow)
 		if len(t.root.items) >= t.maxPairs() {
@@ -667,284 +1044,875 @@ func (t *PairTree) ReplaceOrInsert(item pair.Pair) pair.Pair {
 			t.root = t.cow.newNode()
 			t.root.items = append(t.root.items, item2)
 			t.root.children = append(t.root.children, oldroot, second)
+			t.root.count = oldroot.count + second.count + 1
 		}
 	}
-	out := t.root.insert(item, t.maxPairs(), t.less)
-	if out == nilPair {
+	out, replaced := t.root.insert(item, t.maxPairs(), &t.ordering)
+	if !replaced {
 		t.length++
 	}
-	return out
+	return out, replaced
 }
 
 // Delete removes an item equal to the passed in item from the tree, returning
 // it.  If no such item exists, returns nil.
 func (t *PairTree) Delete(item pair.Pair) pair.Pair {
-	return t.deletePair(item, removePair, t.less)
+	out, _ := t.deletePair(item, removePair)
+	return out
 }
 
 // DeleteMin removes the smallest item in the tree and returns it.
 // If no such item exists, returns nil.
 func (t *PairTree) DeleteMin() pair.Pair {
-	return t.deletePair(nilPair, removeMin, t.less)
+	out, _ := t.deletePair(nilPair, removeMin)
+	return out
 }
 
 // DeleteMax removes the largest item in the tree and returns it.
 // If no such item exists, returns nil.
 func (t *PairTree) DeleteMax() pair.Pair {
-	return t.deletePair(nilPair, removeMax, t.less)
+	out, _ := t.deletePair(nilPair, removeMax)
+	return out
 }
 
-func (t *PairTree) deletePair(item pair.Pair, typ toRemove, less func(a, b pair.Pair) bool) pair.Pair {
+func (t *btree[T]) deletePair(item T, typ toRemove) (_ T, _ bool) {
 	if t.root == nil || len(t.root.items) == 0 {
-		return nilPair
+		return
 	}
+	t.mods++
 	t.root = t.root.mutableFor(t.cow)
-	out := t.root.remove(item, t.minPairs(), typ, less)
+	out, removed := t.root.remove(item, t.minPairs(), typ, &t.ordering)
 	if len(t.root.items) == 0 && len(t.root.children) > 0 {
 		oldroot := t.root
 		t.root = t.root.children[0]
 		t.cow.freeNode(oldroot)
 	}
-	if out != nilPair {
+	if removed {
 		t.length--
 	}
-	return out
+	return out, removed
+}
+
+// ErrNotSorted is returned when loading pairs that are not in ascending order.
+var ErrNotSorted = errors.New("pairtree: pairs are not sorted")
+
+// BulkLoad replaces the contents of the tree with the given pairs, which must
+// be sorted in ascending order according to the tree's less function.
+//
+// The tree is built bottom-up from fully packed nodes in O(n) time, which is
+// much faster than calling ReplaceOrInsert for each pair. Should equivalent
+// pairs be found, only the last one is kept, just as it would be by
+// ReplaceOrInsert. ErrNotSorted is returned, and the tree is left untouched,
+// if the pairs are out of order.
+//
+// nil cannot be added to the tree (will panic).
+func (t *PairTree) BulkLoad(sorted []pair.Pair) error {
+	var deduped []pair.Pair
+	for i, item := range sorted {
+		if item == nilPair {
+			panic("nil item being added to BTree")
+		}
+		if i == 0 {
+			continue
+		}
+		if t.less(item, sorted[i-1]) {
+			return ErrNotSorted
+		}
+		if !t.less(sorted[i-1], item) {
+			// Equivalent to the previous item, which is replaced.
+			if deduped == nil {
+				deduped = append(make([]pair.Pair, 0, len(sorted)), sorted[:i]...)
+			}
+			deduped[len(deduped)-1] = item
+		} else if deduped != nil {
+			deduped = append(deduped, item)
+		}
+	}
+	if deduped != nil {
+		sorted = deduped
+	}
+	t.load(sorted)
+	return nil
+}
+
+// load replaces the contents of the tree with the given pairs, which must be
+// sorted and free of equivalent items.
+func (t *btree[T]) load(sorted []T) {
+	t.root = nil
+	t.length = len(sorted)
+	t.mods++
+	if len(sorted) == 0 {
+		return
+	}
+	// maxSizes[h] is the number of items in a full subtree of height h+1.
+	maxSizes := []int{t.maxPairs()}
+	for maxSizes[len(maxSizes)-1] < len(sorted) {
+		maxSizes = append(maxSizes, (maxSizes[len(maxSizes)-1]+1)*(t.maxPairs()+1)-1)
+	}
+	t.root = t.buildSorted(sorted, maxSizes, true)
+}
+
+// FromSorted creates a new B-Tree from the pairs yielded by iter, which must
+// be sorted in ascending order according to less. See BulkLoad for details.
+func FromSorted(less func(a, b pair.Pair) bool, iter func(yield func(pair.Pair) bool)) (*PairTree, error) {
+	var sorted []pair.Pair
+	iter(func(item pair.Pair) bool {
+		sorted = append(sorted, item)
+		return true
+	})
+	t := New(less)
+	if err := t.BulkLoad(sorted); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// buildSorted builds a subtree of height len(maxSizes) containing the sorted
+// items. Each node is packed as full as possible while ensuring that no node,
+// other than the root, has fewer than minPairs items.
+func (t *btree[T]) buildSorted(sorted []T, maxSizes []int, root bool) *nodeG[T] {
+	n := t.cow.newNode()
+	n.count = len(sorted)
+	if len(maxSizes) == 1 {
+		n.items = append(n.items, sorted...)
+		return n
+	}
+	// Each child takes a share of the items plus the separator that follows
+	// it, so the shares add up to len(sorted)+1.
+	childMax := maxSizes[len(maxSizes)-2]
+	total := len(sorted) + 1
+	k := (total + childMax) / (childMax + 1)
+	if root && k < 2 {
+		k = 2
+	} else if !root && k < t.degree {
+		k = t.degree
+	}
+	share, extra := total/k, total%k
+	for i := 0; i < k; i++ {
+		size := share - 1
+		if i < extra {
+			size++
+		}
+		n.children = append(n.children, t.buildSorted(sorted[:size], maxSizes[:len(maxSizes)-1], false))
+		if i < k-1 {
+			n.items = append(n.items, sorted[size])
+			sorted = sorted[size+1:]
+		}
+	}
+	return n
 }
 
 // AscendRange calls the iterator for every value in the tree within the range
 // [greaterOrEqual, lessThan), until iterator returns false.
 func (t *PairTree) AscendRange(greaterOrEqual, lessThan pair.Pair, iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(ascend, greaterOrEqual, lessThan, true, false, iterator, t.less)
+	t.iterate(ascend, bound(greaterOrEqual), bound(lessThan), true, iterator)
 }
 
 // AscendLessThan calls the iterator for every value in the tree within the range
 // [first, pivot), until iterator returns false.
 func (t *PairTree) AscendLessThan(pivot pair.Pair, iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(ascend, nilPair, pivot, false, false, iterator, t.less)
+	t.iterate(ascend, empty[pair.Pair](), bound(pivot), false, iterator)
 }
 
 // AscendGreaterOrEqual calls the iterator for every value in the tree within
 // the range [pivot, last], until iterator returns false.
 func (t *PairTree) AscendGreaterOrEqual(pivot pair.Pair, iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(ascend, pivot, nilPair, true, false, iterator, t.less)
+	t.iterate(ascend, bound(pivot), empty[pair.Pair](), true, iterator)
 }
 
 // Ascend calls the iterator for every value in the tree within the range
 // [first, last], until iterator returns false.
 func (t *PairTree) Ascend(iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(ascend, nilPair, nilPair, false, false, iterator, t.less)
+	t.iterate(ascend, empty[pair.Pair](), empty[pair.Pair](), false, iterator)
 }
 
 // DescendRange calls the iterator for every value in the tree within the range
 // [lessOrEqual, greaterThan), until iterator returns false.
 func (t *PairTree) DescendRange(lessOrEqual, greaterThan pair.Pair, iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(descend, lessOrEqual, greaterThan, true, false, iterator, t.less)
+	t.iterate(descend, bound(lessOrEqual), bound(greaterThan), true, iterator)
 }
 
 // DescendLessOrEqual calls the iterator for every value in the tree within the range
 // [pivot, first], until iterator returns false.
 func (t *PairTree) DescendLessOrEqual(pivot pair.Pair, iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(descend, pivot, nilPair, true, false, iterator, t.less)
+	t.iterate(descend, bound(pivot), empty[pair.Pair](), true, iterator)
 }
 
 // DescendGreaterThan calls the iterator for every value in the tree within
 // the range (pivot, last], until iterator returns false.
 func (t *PairTree) DescendGreaterThan(pivot pair.Pair, iterator func(item pair.Pair) bool) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(descend, nilPair, pivot, false, false, iterator, t.less)
+	t.iterate(descend, empty[pair.Pair](), bound(pivot), false, iterator)
 }
 
 // Descend calls the iterator for every value in the tree within the range
 // [last, first], until iterator returns false.
 func (t *PairTree) Descend(iterator func(item pair.Pair) bool) {
+	t.iterate(descend, empty[pair.Pair](), empty[pair.Pair](), false, iterator)
+}
+
+func (t *btree[T]) iterate(dir direction, start, stop optionalItem[T], includeStart bool, iterator func(item T) bool) {
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(descend, nilPair, nilPair, false, false, iterator, t.less)
+	t.root.iterate(dir, start, stop, includeStart, false, iterator, &t.ordering)
 }
 
 // Get looks for the key item in the tree, returning it.  It returns nil if
 // unable to find that item.
 func (t *PairTree) Get(key pair.Pair) pair.Pair {
+	out, _ := t.get(key)
+	return out
+}
+
+func (t *btree[T]) get(key T) (_ T, _ bool) {
 	if t.root == nil {
-		return nilPair
+		return
 	}
-	return t.root.get(key, t.less)
+	return t.root.get(key, &t.ordering)
+}
+
+// Floor returns the greatest item in the tree that is less than or equal to
+// key, and whether such an item exists.
+func (t *PairTree) Floor(key pair.Pair) (pair.Pair, bool) {
+	return t.nearest(key, descend, true)
+}
+
+// Ceiling returns the least item in the tree that is greater than or equal
+// to key, and whether such an item exists.
+func (t *PairTree) Ceiling(key pair.Pair) (pair.Pair, bool) {
+	return t.nearest(key, ascend, true)
+}
+
+// Lower returns the greatest item in the tree that is strictly less than key,
+// and whether such an item exists.
+func (t *PairTree) Lower(key pair.Pair) (pair.Pair, bool) {
+	return t.nearest(key, descend, false)
+}
+
+// Higher returns the least item in the tree that is strictly greater than
+// key, and whether such an item exists.
+func (t *PairTree) Higher(key pair.Pair) (pair.Pair, bool) {
+	return t.nearest(key, ascend, false)
+}
+
+func (t *btree[T]) nearest(key T, dir direction, inclusive bool) (_ T, _ bool) {
+	if t.root == nil {
+		return
+	}
+	return t.root.nearest(key, dir, inclusive, &t.ordering)
 }
 
 // Min returns the smallest item in the tree, or nil if the tree is empty.
 func (t *PairTree) Min() pair.Pair {
-	return min(t.root)
+	out, _ := min(t.root)
+	return out
 }
 
 // Max returns the largest item in the tree, or nil if the tree is empty.
 func (t *PairTree) Max() pair.Pair {
-	return max(t.root)
+	out, _ := max(t.root)
+	return out
 }
 
 // Has returns true if the given key is in the tree.
 func (t *PairTree) Has(key pair.Pair) bool {
-	return t.Get(key) != nilPair
+	_, ok := t.get(key)
+	return ok
 }
 
 // Len returns the number of items currently in the tree.
-func (t *PairTree) Len() int {
+func (t *btree[T]) Len() int {
 	return t.length
 }
 
-type stackPair struct {
-	n *node // current node
-	i int   // index of the next child/item.
+// GetAt returns the item at index i in the sorted order of the tree.  It
+// returns nil if i is out of range.
+func (t *PairTree) GetAt(i int) pair.Pair {
+	out, _ := t.getAt(i)
+	return out
+}
+
+func (t *btree[T]) getAt(i int) (_ T, _ bool) {
+	if i < 0 || i >= t.length {
+		return
+	}
+	return t.root.getAt(i), true
+}
+
+// DeleteAt removes the item at index i in the sorted order of the tree,
+// returning it.  If i is out of range, returns nil.
+func (t *PairTree) DeleteAt(i int) pair.Pair {
+	out, _ := t.deleteAt(i)
+	return out
+}
+
+func (t *btree[T]) deleteAt(i int) (_ T, _ bool) {
+	item, ok := t.getAt(i)
+	if !ok {
+		return
+	}
+	return t.deletePair(item, removePair)
+}
+
+// Rank returns the number of items in the tree that are less than key.  When
+// the key is in the tree this is its index, such that GetAt(Rank(key))
+// returns it.
+func (t *PairTree) Rank(key pair.Pair) int {
+	return t.rank(key)
+}
+
+func (t *btree[T]) rank(key T) int {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.rank(key, &t.ordering)
+}
+
+// CountRange returns the number of items in the tree within the range
+// [greaterOrEqual, lessThan).
+func (t *PairTree) CountRange(greaterOrEqual, lessThan pair.Pair) int {
+	return t.countRange(bound(greaterOrEqual), bound(lessThan))
+}
+
+func (t *btree[T]) countRange(greaterOrEqual, lessThan optionalItem[T]) int {
+	lo, hi := 0, t.length
+	if greaterOrEqual.valid {
+		lo = t.rank(greaterOrEqual.item)
+	}
+	if lessThan.valid {
+		hi = t.rank(lessThan.item)
+	}
+	if hi < lo {
+		return 0
+	}
+	return hi - lo
+}
+
+type stackPair[T any] struct {
+	n *nodeG[T] // current node
+	i int       // index of the next child/item.
+}
+
+// cursor is the generic implementation shared by Cursor and CursorG.
+type cursor[T any] struct {
+	t     *btree[T]
+	stack []stackPair[T]
+
+	// The bounds of the cursor, which are ignored when not valid.
+	lo, hi                   optionalItem[T]
+	loInclusive, hiInclusive bool
+
+	// The cursor remembers the item it is positioned on, and the
+	// modification count of the tree at that time.  A stable cursor uses
+	// them to seek back to its position after the tree is modified, while
+	// other cursors report the modification through err.
+	stable bool
+	item   T
+	ok     bool
+	mods   uint64
+	err    error
 }
 
+// ErrModified is returned by the Err method of a cursor that is not stable
+// when it moved through a tree that was modified, other than through the
+// cursor itself, since the cursor was positioned.
+var ErrModified = errors.New("pairtree: tree modified during traversal")
+
 // Cursor represents an iterator that can traverse over all items in the tree
 // in sorted order.
 //
+// A cursor is positioned on an item after a successful move, at which point
+// Valid returns true and Item, Key and Value describe the item.  Once a move
+// finds no item, such as when Next moves past the last item, Valid returns
+// false until the cursor is moved onto an item again.
+//
 // Changing data while traversing a cursor may result in unexpected items to
-// be returned. You must reposition your cursor after mutating data.
+// be returned, which is reported by Err. You must reposition your cursor after
+// mutating data, unless the cursor was created with StableCursor.
 type Cursor struct {
-	t     *PairTree
-	stack []stackPair
+	c cursor[pair.Pair]
 }
 
 // Cursor returns a new cursor used to traverse over items in the tree.
 func (t *PairTree) Cursor() *Cursor {
-	return &Cursor{t: t}
+	return &Cursor{cursor[pair.Pair]{t: &t.btree}}
+}
+
+// StableCursor returns a new cursor used to traverse over items in the tree,
+// which stays valid when the tree is modified.
+//
+// The cursor remembers the last item it returned.  When Next or Prev finds
+// that the tree has been modified since then, the cursor first seeks back to
+// that item's position, so Next returns the item following it and Prev the
+// item preceding it, even if the item itself has since been deleted.  This
+// re-seek costs O(log n), and only happens after a modification.
+func (t *PairTree) StableCursor() *Cursor {
+	return &Cursor{cursor[pair.Pair]{t: &t.btree, stable: true}}
+}
+
+// CursorRange returns a new cursor used to traverse over the items in the
+// tree between lo and hi.  Each bound includes an item equal to it when its
+// inclusive flag is set, and a nil bound leaves that end of the range open.
+//
+// First and Last move to the first and last item within the range, and Next,
+// Prev and the Seek methods report no item once they would leave it.
+func (t *PairTree) CursorRange(lo, hi pair.Pair, loInclusive, hiInclusive bool) *Cursor {
+	return &Cursor{cursor[pair.Pair]{
+		t:  &t.btree,
+		lo: bound(lo), hi: bound(hi),
+		loInclusive: loInclusive, hiInclusive: hiInclusive,
+	}}
 }
 
 // First moves the cursor to the first item in the tree and returns that item.
 func (c *Cursor) First() pair.Pair {
+	item, _ := c.c.first()
+	return item
+}
+
+// Next moves the cursor to the next item and returns that item.
+func (c *Cursor) Next() pair.Pair {
+	item, _ := c.c.next()
+	return item
+}
+
+// Last moves the cursor to the last item in the tree and returns that item.
+func (c *Cursor) Last() pair.Pair {
+	item, _ := c.c.last()
+	return item
+}
+
+// Prev moves the cursor to the previous item and returns that item.
+func (c *Cursor) Prev() pair.Pair {
+	item, _ := c.c.prev()
+	return item
+}
+
+// Seek moves the cursor to provided item and returns that item.
+// If the item does not exist then the next item is returned.
+func (c *Cursor) Seek(pivot pair.Pair) pair.Pair {
+	item, _ := c.c.seek(pivot)
+	return item
+}
+
+// SeekGT moves the cursor to the first item greater than pivot and returns
+// that item.
+func (c *Cursor) SeekGT(pivot pair.Pair) pair.Pair {
+	item, _ := c.c.seekGT(pivot)
+	return item
+}
+
+// SeekLE moves the cursor to provided item and returns that item.
+// If the item does not exist then the previous item is returned.
+func (c *Cursor) SeekLE(pivot pair.Pair) pair.Pair {
+	item, _ := c.c.seekLE(pivot)
+	return item
+}
+
+// SeekLT moves the cursor to the last item less than pivot and returns that
+// item.
+func (c *Cursor) SeekLT(pivot pair.Pair) pair.Pair {
+	item, _ := c.c.seekLT(pivot)
+	return item
+}
+
+// Valid returns true if the cursor is positioned on an item.
+func (c *Cursor) Valid() bool {
+	return c.c.ok
+}
+
+// Item returns the item under the cursor, or nil if the cursor is not
+// positioned on an item.
+func (c *Cursor) Item() pair.Pair {
+	return c.c.item
+}
+
+// Key returns the key of the item under the cursor, or nil if the cursor is
+// not positioned on an item.
+func (c *Cursor) Key() []byte {
+	if !c.c.ok {
+		return nil
+	}
+	return c.c.item.Key()
+}
+
+// Value returns the value of the item under the cursor, or nil if the cursor
+// is not positioned on an item.
+func (c *Cursor) Value() []byte {
+	if !c.c.ok {
+		return nil
+	}
+	return c.c.item.Value()
+}
+
+// Err returns ErrModified if Next, Prev, Delete or Replace was called after
+// the tree was modified, and nil otherwise.  The error is cleared when the
+// cursor is repositioned with First, Last or one of the Seek methods.
+func (c *Cursor) Err() error {
+	return c.c.err
+}
+
+// Delete removes the item under the cursor from the tree, then moves the
+// cursor to the next item and returns that item.  If the cursor is not
+// positioned on an item, nothing is removed and nil is returned.  Unless the
+// cursor was created with StableCursor, nothing is removed either if the tree
+// has been modified since the cursor was positioned, and Err returns
+// ErrModified.
+func (c *Cursor) Delete() pair.Pair {
+	item, _ := c.c.delete()
+	return item
+}
+
+// Replace replaces the item under the cursor with item, which must be
+// equivalent to it, returning the replaced item.  The tree is not
+// restructured, and nodes shared with clones are copied before being
+// changed.  If the cursor is not positioned on an item, or it is not stable
+// and the tree has been modified since it was positioned, nothing is replaced
+// and nil is returned, as with Delete.
+//
+// It panics if item is nil or is not equivalent to the item under the
+// cursor.
+func (c *Cursor) Replace(item pair.Pair) pair.Pair {
+	if item == nilPair {
+		panic("nil item being added to BTree")
+	}
+	out, _ := c.c.replace(item)
+	return out
+}
+
+func (c *cursor[T]) first() (T, bool) {
+	c.err = nil
+	switch {
+	case !c.lo.valid:
+		return c.track(c.toFirst())
+	case c.loInclusive:
+		return c.track(c.seekAtOrAfter(c.lo.item))
+	default:
+		return c.track(c.seekAfter(c.lo.item))
+	}
+}
+
+func (c *cursor[T]) next() (T, bool) {
+	if c.checkModified() || c.stale() {
+		return c.track(c.seekAfter(c.item))
+	}
+	return c.track(c.stepNext())
+}
+
+func (c *cursor[T]) last() (T, bool) {
+	c.err = nil
+	switch {
+	case !c.hi.valid:
+		return c.track(c.toLast())
+	case c.hiInclusive:
+		return c.track(c.seekAtOrBefore(c.hi.item))
+	default:
+		return c.track(c.seekBefore(c.hi.item))
+	}
+}
+
+func (c *cursor[T]) prev() (T, bool) {
+	if c.checkModified() || c.stale() {
+		return c.track(c.seekBefore(c.item))
+	}
+	return c.track(c.stepPrev())
+}
+
+func (c *cursor[T]) seek(pivot T) (T, bool) {
+	c.err = nil
+	if c.beforeLo(pivot) {
+		return c.first()
+	}
+	return c.track(c.seekAtOrAfter(pivot))
+}
+
+func (c *cursor[T]) seekGT(pivot T) (T, bool) {
+	c.err = nil
+	if c.beforeLo(pivot) {
+		return c.first()
+	}
+	return c.track(c.seekAfter(pivot))
+}
+
+func (c *cursor[T]) seekLE(pivot T) (T, bool) {
+	c.err = nil
+	if c.afterHi(pivot) {
+		return c.last()
+	}
+	return c.track(c.seekAtOrBefore(pivot))
+}
+
+func (c *cursor[T]) seekLT(pivot T) (T, bool) {
+	c.err = nil
+	if c.afterHi(pivot) {
+		return c.last()
+	}
+	return c.track(c.seekBefore(pivot))
+}
+
+// beforeLo returns true if item is before the lower bound of the cursor.
+func (c *cursor[T]) beforeLo(item T) bool {
+	if !c.lo.valid {
+		return false
+	} else if c.loInclusive {
+		return c.t.less(item, c.lo.item)
+	}
+	return !c.t.less(c.lo.item, item)
+}
+
+// afterHi returns true if item is after the upper bound of the cursor.
+func (c *cursor[T]) afterHi(item T) bool {
+	if !c.hi.valid {
+		return false
+	} else if c.hiInclusive {
+		return c.t.less(c.hi.item, item)
+	}
+	return !c.t.less(item, c.hi.item)
+}
+
+// track checks that the item the cursor moved to is within its bounds,
+// leaving the cursor unpositioned if it is not, and records the item.
+func (c *cursor[T]) track(item T, ok bool) (T, bool) {
+	if ok && (c.beforeLo(item) || c.afterHi(item)) {
+		var zero T
+		item, ok = zero, false
+		c.stack = c.stack[:0]
+	}
+	c.item, c.ok, c.mods = item, ok, c.t.mods
+	return item, ok
+}
+
+// checkModified sets err and returns true if the cursor is not stable and the
+// tree has been modified since it moved to its item.  The stack of the cursor
+// may then refer to nodes that have been changed or reused, so rather than
+// being stepped, the cursor moves on from its item by seeking, as a stable
+// cursor does.
+func (c *cursor[T]) checkModified() bool {
+	if !c.stable && c.ok && c.mods != c.t.mods {
+		c.err = ErrModified
+		return true
+	}
+	return false
+}
+
+// stale returns true if the cursor is stable and is positioned on an item,
+// but the tree has been modified since it moved there.
+func (c *cursor[T]) stale() bool {
+	return c.stable && c.ok && c.mods != c.t.mods
+}
+
+// current returns the item under the cursor.  A stale stable cursor is first
+// moved back to its item, and returns false if the item has been deleted.  A
+// cursor that is not stable returns false and sets err if the tree has been
+// modified since it moved to its item, since its stack may then refer to
+// nodes that have been changed or reused.
+func (c *cursor[T]) current() (_ T, _ bool) {
+	if !c.ok {
+		return
+	}
+	if !c.stable && c.mods != c.t.mods {
+		c.err = ErrModified
+		return
+	}
+	if c.stale() {
+		item, ok := c.seekAtOrAfter(c.item)
+		if !ok || c.t.less(c.item, item) {
+			return
+		}
+		c.track(item, ok)
+	}
+	if len(c.stack) == 0 {
+		return
+	}
+	top := c.stack[len(c.stack)-1]
+	i := top.i
+	if len(top.n.children) > 0 {
+		if i%2 == 0 {
+			return
+		}
+		i /= 2
+	}
+	if i < 0 || i >= len(top.n.items) {
+		return
+	}
+	return top.n.items[i], true
+}
+
+func (c *cursor[T]) delete() (_ T, _ bool) {
+	item, ok := c.current()
+	if !ok {
+		return
+	}
+	c.t.deletePair(item, removePair)
+	return c.seek(item)
+}
+
+func (c *cursor[T]) replace(item T) (_ T, _ bool) {
+	out, ok := c.current()
+	if !ok {
+		return
+	}
+	if c.t.less(item, out) || c.t.less(out, item) {
+		panic("replacement item is not equivalent to the item under the cursor")
+	}
+	// Make every node on the path to the item writable, copying those that
+	// are shared with clones, and update the stack to match.
+	c.t.mods++
+	c.t.root = c.t.root.mutableFor(c.t.cow)
+	c.stack[0].n = c.t.root
+	for k := 1; k < len(c.stack); k++ {
+		c.stack[k].n = c.stack[k-1].n.mutableChild(c.stack[k-1].i / 2)
+	}
+	top := c.stack[len(c.stack)-1]
+	if len(top.n.children) > 0 {
+		top.n.items[top.i/2] = item
+	} else {
+		top.n.items[top.i] = item
+	}
+	c.track(item, true)
+	return out, true
+}
+
+func (c *cursor[T]) toFirst() (_ T, _ bool) {
 	c.stack = c.stack[:0]
 	n := c.t.root
 	if n == nil {
-		return nilPair
+		return
 	}
-	c.stack = append(c.stack, stackPair{n: n})
+	c.stack = append(c.stack, stackPair[T]{n: n})
 	for len(n.children) > 0 {
 		n = n.children[0]
-		c.stack = append(c.stack, stackPair{n: n})
+		c.stack = append(c.stack, stackPair[T]{n: n})
 	}
 	if len(n.items) == 0 {
-		return nilPair
+		return
 	}
-	return n.items[0]
+	return n.items[0], true
 }
 
-// Next moves the cursor to the next item and returns that item.
-func (c *Cursor) Next() pair.Pair {
-	if len(c.stack) == 0 {
-		return nilPair
-	}
-	si := len(c.stack) - 1
-	c.stack[si].i++
-	n := c.stack[si].n
-	i := c.stack[si].i
-	if i == len(n.children)+len(n.items) {
-		c.stack = c.stack[:len(c.stack)-1]
-		return c.Next()
-	}
-	if len(n.children) == 0 {
-		if i >= len(n.items) {
+func (c *cursor[T]) stepNext() (_ T, _ bool) {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		top.i++
+		n, i := top.n, top.i
+		switch {
+		case len(n.children) == 0:
+			if i < len(n.items) {
+				return n.items[i], true
+			}
+			c.stack = c.stack[:len(c.stack)-1]
+		case i == len(n.children)+len(n.items):
 			c.stack = c.stack[:len(c.stack)-1]
-			return c.Next()
+		case i%2 == 1:
+			return n.items[i/2], true
+		default:
+			c.stack = append(c.stack, stackPair[T]{n: n.children[i/2], i: -1})
 		}
-		return n.items[i]
-	} else if i%2 == 1 {
-		return n.items[i/2]
 	}
-	c.stack = append(c.stack, stackPair{n: n.children[i/2], i: -1})
-	return c.Next()
-
+	return
 }
 
-// Last moves the cursor to the last item in the tree and returns that item.
-func (c *Cursor) Last() pair.Pair {
+func (c *cursor[T]) toLast() (_ T, _ bool) {
 	c.stack = c.stack[:0]
 	n := c.t.root
 	if n == nil {
-		return nilPair
+		return
 	}
-	c.stack = append(c.stack, stackPair{n: n, i: len(n.children) + len(n.items) - 1})
+	c.stack = append(c.stack, stackPair[T]{n: n, i: len(n.children) + len(n.items) - 1})
 	for len(n.children) > 0 {
 		n = n.children[len(n.children)-1]
-		c.stack = append(c.stack, stackPair{n: n, i: len(n.children) + len(n.items) - 1})
+		c.stack = append(c.stack, stackPair[T]{n: n, i: len(n.children) + len(n.items) - 1})
 	}
 	if len(n.items) == 0 {
-		return nilPair
+		return
 	}
-	return n.items[len(n.items)-1]
+	return n.items[len(n.items)-1], true
 }
 
-// Prev moves the cursor to the previous item and returns that item.
-func (c *Cursor) Prev() pair.Pair {
-	if len(c.stack) == 0 {
-		return nilPair
+func (c *cursor[T]) stepPrev() (_ T, _ bool) {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		top.i--
+		n, i := top.n, top.i
+		switch {
+		case i == -1:
+			c.stack = c.stack[:len(c.stack)-1]
+		case len(n.children) == 0:
+			if i < len(n.items) {
+				return n.items[i], true
+			}
+			c.stack = c.stack[:len(c.stack)-1]
+		case i%2 == 1:
+			return n.items[i/2], true
+		default:
+			child := n.children[i/2]
+			c.stack = append(c.stack, stackPair[T]{n: child,
+				i: len(child.children) + len(child.items)})
+		}
 	}
-	si := len(c.stack) - 1
-	c.stack[si].i--
-	n := c.stack[si].n
-	i := c.stack[si].i
-	if i == -1 {
-		c.stack = c.stack[:len(c.stack)-1]
-		return c.Prev()
+	return
+}
+
+// seekAfter moves the cursor to the first item greater than pivot.
+func (c *cursor[T]) seekAfter(pivot T) (T, bool) {
+	item, ok := c.seekAtOrAfter(pivot)
+	if ok && !c.t.less(pivot, item) {
+		return c.stepNext()
 	}
-	if len(n.children) == 0 {
-		return n.items[i]
-	} else if i%2 == 1 {
-		return n.items[i/2]
+	return item, ok
+}
+
+// seekAtOrBefore moves the cursor to the last item less than or equal to
+// pivot.
+func (c *cursor[T]) seekAtOrBefore(pivot T) (T, bool) {
+	item, ok := c.seekAtOrAfter(pivot)
+	if ok && !c.t.less(pivot, item) {
+		return item, ok
 	}
-	child := n.children[i/2]
-	c.stack = append(c.stack, stackPair{n: child,
-		i: len(child.children) + len(child.items)})
-	return c.Prev()
+	return c.stepBack(ok)
 }
 
-// Seek moves the cursor to provided item and returns that item.
-// If the item does not exist then the next item is returned.
-func (c *Cursor) Seek(pivot pair.Pair) pair.Pair {
+// seekBefore moves the cursor to the last item less than pivot.
+func (c *cursor[T]) seekBefore(pivot T) (T, bool) {
+	_, ok := c.seekAtOrAfter(pivot)
+	return c.stepBack(ok)
+}
+
+// stepBack moves the cursor back from the item found by seekAtOrAfter, or to
+// the last item when no item was found.
+func (c *cursor[T]) stepBack(found bool) (T, bool) {
+	if found {
+		return c.stepPrev()
+	}
+	return c.toLast()
+}
+
+// seekAtOrAfter moves the cursor to the first item greater than or equal to
+// pivot.
+func (c *cursor[T]) seekAtOrAfter(pivot T) (_ T, _ bool) {
 	c.stack = c.stack[:0]
 	n := c.t.root
 	for n != nil {
-		i, found := n.items.find(pivot, c.t.less)
-		c.stack = append(c.stack, stackPair{n: n})
+		i, found := n.items.find(pivot, &c.t.ordering)
+		c.stack = append(c.stack, stackPair[T]{n: n})
 		if found {
 			if len(n.children) == 0 {
 				c.stack[len(c.stack)-1].i = i
 			} else {
 				c.stack[len(c.stack)-1].i = i*2 + 1
 			}
-			return n.items[i]
+			return n.items[i], true
 		}
 		if len(n.children) == 0 {
 			if i == len(n.items) {
 				c.stack[len(c.stack)-1].i = i + 1
-				return c.Next()
+				return c.stepNext()
 			}
 			c.stack[len(c.stack)-1].i = i
-			return n.items[i]
+			return n.items[i], true
 		}
 		c.stack[len(c.stack)-1].i = i * 2
 		n = n.children[i]
 	}
-	return nilPair
+	return
 }
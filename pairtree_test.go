@@ -165,6 +165,366 @@ func TestBTree(t *testing.T) {
 	}
 }
 
+// checkTree verifies the structural invariants of the tree.
+func checkTree(t *testing.T, tr *PairTree) {
+	t.Helper()
+	if tr.root == nil {
+		if tr.length != 0 {
+			t.Fatalf("empty tree has length %d", tr.length)
+		}
+		return
+	}
+	var leafDepth = -1
+	var check func(n *node, depth int) int
+	check = func(n *node, depth int) int {
+		if n != tr.root && (len(n.items) < tr.minPairs() || len(n.items) > tr.maxPairs()) {
+			t.Fatalf("node has %d items, want [%d, %d]", len(n.items), tr.minPairs(), tr.maxPairs())
+		}
+		for i := 1; i < len(n.items); i++ {
+			if !tr.less(n.items[i-1], n.items[i]) {
+				t.Fatalf("items out of order")
+			}
+		}
+		count := len(n.items)
+		if len(n.children) == 0 {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				t.Fatalf("leaf at depth %d, want %d", depth, leafDepth)
+			}
+		} else if len(n.children) != len(n.items)+1 {
+			t.Fatalf("node has %d items and %d children", len(n.items), len(n.children))
+		}
+		for _, c := range n.children {
+			count += check(c, depth+1)
+		}
+		if count != n.count {
+			t.Fatalf("node has %d items in subtree, count is %d", count, n.count)
+		}
+		return count
+	}
+	if count := check(tr.root, 0); count != tr.length {
+		t.Fatalf("counted %d items, length is %d", count, tr.length)
+	}
+}
+
+func TestDegrees(t *testing.T) {
+	for _, degree := range []int{2, 3, 4, 9, 32} {
+		tr := NewWithOptions(Options{Degree: degree, FreeListSize: 4, Less: lessFn})
+		for _, item := range perm(1000) {
+			tr.ReplaceOrInsert(item)
+		}
+		checkTree(t, tr)
+		if got, want := all(tr), rang(1000); !IntDeepEqual(got, want) {
+			t.Fatalf("degree %d: mismatch:\n got: %v\nwant: %v", degree, got, want)
+		}
+		for _, item := range perm(1000)[:500] {
+			if x := tr.Delete(item); x == nilPair {
+				t.Fatalf("degree %d: didn't find %v", degree, IntStr(item))
+			}
+		}
+		checkTree(t, tr)
+		if tr.Len() != 500 {
+			t.Fatalf("degree %d: expected 500 items, got %d", degree, tr.Len())
+		}
+	}
+}
+
+func TestNewWithOptionsInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Degree: 1},
+		{Degree: -1},
+		{FreeListSize: -1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for %+v", opts)
+				}
+			}()
+			NewWithOptions(opts)
+		}()
+	}
+	tr := NewWithOptions(Options{})
+	if tr.degree != defaultDegrees {
+		t.Fatalf("expected degree %d, got %d", defaultDegrees, tr.degree)
+	}
+}
+
+func TestSharedFreeList(t *testing.T) {
+	fl := NewFreeList(64)
+	tr1 := NewWithFreeList(fl, lessFn)
+	tr2 := NewWithOptions(Options{Degree: 2, FreeList: fl, Less: lessFn})
+	for _, item := range perm(100) {
+		tr1.ReplaceOrInsert(item)
+		tr2.ReplaceOrInsert(item)
+	}
+	stats := fl.Stats()
+	if stats.Hits != 0 || stats.Misses == 0 || stats.Size != 0 {
+		t.Fatalf("unexpected stats after inserts: %+v", stats)
+	}
+	for _, item := range perm(100) {
+		tr1.Delete(item)
+	}
+	freed := fl.Stats().Size
+	if freed == 0 {
+		t.Fatalf("expected nodes on the free list")
+	}
+	for _, item := range perm(100) {
+		tr2.Delete(item)
+		tr1.ReplaceOrInsert(item)
+	}
+	stats = fl.Stats()
+	if stats.Hits == 0 {
+		t.Fatalf("expected nodes to be reused: %+v", stats)
+	}
+	if got, want := all(tr1), rang(100); !IntDeepEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	if tr2.Len() != 0 {
+		t.Fatalf("expected empty tree, got %d items", tr2.Len())
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		for n := 0; n < 2000; n += 1 + n/4 {
+			tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+			tr.ReplaceOrInsert(Int(-1))
+			if err := tr.BulkLoad(rang(n)); err != nil {
+				t.Fatal(err)
+			}
+			checkTree(t, tr)
+			if got, want := all(tr), rang(n); !IntDeepEqual(got, want) {
+				t.Fatalf("degree %d, n %d: mismatch:\n got: %v\nwant: %v", degree, n, got, want)
+			}
+			for _, item := range perm(n) {
+				if x := tr.Delete(item); x == nilPair {
+					t.Fatalf("degree %d, n %d: didn't find %v", degree, n, IntStr(item))
+				}
+			}
+			checkTree(t, tr)
+		}
+	}
+}
+
+func TestBulkLoadInvalid(t *testing.T) {
+	tr := New(lessFn)
+	tr.ReplaceOrInsert(Int(100))
+	if err := tr.BulkLoad([]pair.Pair{Int(1), Int(3), Int(2)}); err != ErrNotSorted {
+		t.Fatalf("expected ErrNotSorted, got %v", err)
+	}
+	if tr.Len() != 1 || !IntEqual(tr.Min(), Int(100)) {
+		t.Fatalf("tree was modified by failed load")
+	}
+	first := pair.New(Int(2).Key(), []byte("first"))
+	last := pair.New(Int(2).Key(), []byte("last"))
+	if err := tr.BulkLoad([]pair.Pair{Int(1), first, last, Int(3)}); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tr)
+	if tr.Len() != 3 {
+		t.Fatalf("expected 3 items, got %d", tr.Len())
+	}
+	if got := tr.Get(Int(2)); got != last {
+		t.Fatalf("expected last duplicate to be kept, got %q", got.Value())
+	}
+}
+
+func TestFromSorted(t *testing.T) {
+	items := rang(500)
+	tr, err := FromSorted(lessFn, func(yield func(pair.Pair) bool) {
+		for _, item := range items {
+			if !yield(item) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tr)
+	if got := all(tr); !IntDeepEqual(got, items) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, items)
+	}
+}
+
+func TestOrderStatistics(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+		for _, v := range rand.Perm(1000) {
+			tr.ReplaceOrInsert(Int(v * 2))
+		}
+		checkTree(t, tr)
+		for i := 0; i < 1000; i++ {
+			if got := tr.GetAt(i); PairInt(got) != i*2 {
+				t.Fatalf("GetAt(%d): expected %d, got %v", i, i*2, IntStr(got))
+			}
+			if got := tr.Rank(Int(i * 2)); got != i {
+				t.Fatalf("Rank(%d): expected %d, got %d", i*2, i, got)
+			}
+			if got := tr.Rank(Int(i*2 + 1)); got != i+1 {
+				t.Fatalf("Rank(%d): expected %d, got %d", i*2+1, i+1, got)
+			}
+		}
+		if got := tr.GetAt(1000); got != nilPair {
+			t.Fatalf("GetAt(1000): expected nil, got %v", IntStr(got))
+		}
+		if got := tr.CountRange(Int(100), Int(301)); got != 101 {
+			t.Fatalf("CountRange: expected 101, got %d", got)
+		}
+		if got := tr.CountRange(Int(301), Int(100)); got != 0 {
+			t.Fatalf("CountRange: expected 0, got %d", got)
+		}
+		if got := tr.CountRange(nilPair, Int(100)); got != 50 {
+			t.Fatalf("CountRange: expected 50, got %d", got)
+		}
+		clone := tr.Clone()
+		for i := 999; i >= 0; i -= 2 {
+			if got := tr.DeleteAt(i); PairInt(got) != i*2 {
+				t.Fatalf("DeleteAt(%d): expected %d, got %v", i, i*2, IntStr(got))
+			}
+		}
+		checkTree(t, tr)
+		checkTree(t, clone)
+		if tr.Len() != 500 || clone.Len() != 1000 {
+			t.Fatalf("expected 500 and 1000 items, got %d and %d", tr.Len(), clone.Len())
+		}
+		for i := 0; i < 500; i++ {
+			if got := tr.GetAt(i); PairInt(got) != i*4 {
+				t.Fatalf("GetAt(%d): expected %d, got %v", i, i*4, IntStr(got))
+			}
+		}
+		for len(all(tr)) > 0 {
+			tr.DeleteMin()
+			tr.DeleteMax()
+			checkTree(t, tr)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	fl := NewFreeList(1024)
+	tr := NewWithOptions(Options{Degree: 2, FreeList: fl, Less: lessFn})
+	for _, item := range perm(100) {
+		tr.ReplaceOrInsert(item)
+	}
+	clone := tr.Clone()
+	for _, item := range perm(10) {
+		tr.Delete(item)
+		tr.ReplaceOrInsert(item)
+	}
+	owned := 0
+	var count func(n *node)
+	count = func(n *node) {
+		if n.cow == tr.cow {
+			owned++
+		}
+		for _, c := range n.children {
+			count(c)
+		}
+	}
+	count(tr.root)
+	before := fl.Stats().Size
+	tr.Clear(true)
+	if got := fl.Stats().Size - before; got != owned {
+		t.Fatalf("expected %d nodes freed, got %d", owned, got)
+	}
+	if tr.Len() != 0 || tr.Min() != nilPair {
+		t.Fatalf("expected empty tree")
+	}
+	checkTree(t, clone)
+	if got, want := all(clone), rang(100); !IntDeepEqual(got, want) {
+		t.Fatalf("clone mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	clone.Clear(false)
+	if clone.Len() != 0 {
+		t.Fatalf("expected empty clone")
+	}
+	for _, item := range perm(100) {
+		tr.ReplaceOrInsert(item)
+	}
+	checkTree(t, tr)
+}
+
+func TestNearest(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+		for _, v := range rand.Perm(500) {
+			tr.ReplaceOrInsert(Int(v * 2))
+		}
+		for k := 0; k < 1000; k++ {
+			for _, tc := range []struct {
+				name string
+				fn   func(pair.Pair) (pair.Pair, bool)
+				want int
+			}{
+				{"Floor", tr.Floor, k - k%2},
+				{"Ceiling", tr.Ceiling, k + k%2},
+				{"Lower", tr.Lower, k - 2 + k%2},
+				{"Higher", tr.Higher, k + 2 - k%2},
+			} {
+				got, ok := tc.fn(Int(k))
+				if tc.want < 0 || tc.want > 998 {
+					if ok || got != nilPair {
+						t.Fatalf("%s(%d): expected none, got %v", tc.name, k, IntStr(got))
+					}
+				} else if !ok || PairInt(got) != tc.want {
+					t.Fatalf("%s(%d): expected %d, got %v", tc.name, k, tc.want, IntStr(got))
+				}
+			}
+		}
+	}
+	if _, ok := New(lessFn).Floor(Int(1)); ok {
+		t.Fatalf("expected no item in empty tree")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	var lessCalls, cmpCalls int
+	less := func(a, b pair.Pair) bool {
+		lessCalls++
+		return lessFn(a, b)
+	}
+	compare := func(a, b pair.Pair) int {
+		cmpCalls++
+		x, y := PairInt(a), PairInt(b)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	}
+	trLess, trCmp := New(less), NewWithCompare(compare)
+	for _, item := range perm(1000) {
+		trLess.ReplaceOrInsert(item)
+		trCmp.ReplaceOrInsert(item)
+	}
+	checkTree(t, trCmp)
+	if got := all(trCmp); !IntDeepEqual(got, rang(1000)) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, rang(1000))
+	}
+	lessCalls, cmpCalls = 0, 0
+	for _, item := range perm(1000) {
+		if !trLess.Has(item) || !trCmp.Has(item) {
+			t.Fatalf("missing %v", IntStr(item))
+		}
+	}
+	if cmpCalls >= lessCalls {
+		t.Fatalf("expected fewer compare calls than less calls, got %d and %d", cmpCalls, lessCalls)
+	}
+	reverse := NewWithOptions(Options{Degree: 2, Compare: func(a, b pair.Pair) int {
+		return compare(b, a)
+	}})
+	for _, item := range perm(100) {
+		reverse.ReplaceOrInsert(item)
+	}
+	if got := all(reverse); !IntDeepEqual(got, rangrev(100)) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, rangrev(100))
+	}
+}
+
 func ExampleBTree() {
 	tr := New(lessFn)
 	for i := 0; i < 10; i++ {
@@ -404,6 +764,17 @@ func BenchmarkInsert(b *testing.B) {
 	}
 }
 
+func BenchmarkBulkLoad(b *testing.B) {
+	items := rang(benchmarkTreeSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr := New(lessFn)
+		if err := tr.BulkLoad(items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkDeleteInsert(b *testing.B) {
 	b.StopTimer()
 	insertP := perm(benchmarkTreeSize)
@@ -676,6 +1047,42 @@ func BenchmarkDescendLessOrEqual(b *testing.B) {
 	}
 }
 
+func BenchmarkCursorNext(b *testing.B) {
+	tr := New(lessFn)
+	for _, v := range perm(benchmarkTreeSize) {
+		tr.ReplaceOrInsert(v)
+	}
+	c := tr.Cursor()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := 0
+		for item := c.First(); item != nilPair; item = c.Next() {
+			j++
+		}
+		if j != benchmarkTreeSize {
+			b.Fatalf("expected: %v, got %v", benchmarkTreeSize, j)
+		}
+	}
+}
+
+func BenchmarkCursorPrev(b *testing.B) {
+	tr := New(lessFn)
+	for _, v := range perm(benchmarkTreeSize) {
+		tr.ReplaceOrInsert(v)
+	}
+	c := tr.Cursor()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := 0
+		for item := c.Last(); item != nilPair; item = c.Prev() {
+			j++
+		}
+		if j != benchmarkTreeSize {
+			b.Fatalf("expected: %v, got %v", benchmarkTreeSize, j)
+		}
+	}
+}
+
 const cloneTestSize = 10000
 
 func cloneTest(t *testing.T, b *PairTree, start int, p []pair.Pair, wg *sync.WaitGroup, trees *[]*PairTree) {
@@ -879,3 +1286,342 @@ func TestCursor(t *testing.T) {
 		}
 	}
 }
+
+func TestStableCursor(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+		for i := 0; i < 1000; i++ {
+			tr.ReplaceOrInsert(Int(i))
+		}
+		// Delete every item while scanning forward, inserting odd items
+		// ahead of the cursor that must be visited too.
+		var got []int
+		c := tr.StableCursor()
+		for item := c.First(); item != nilPair; item = c.Next() {
+			v := PairInt(item)
+			got = append(got, v)
+			tr.Delete(item)
+			if v < 1000 && v%100 == 0 {
+				tr.ReplaceOrInsert(Int(v + 1000))
+			}
+		}
+		checkTree(t, tr)
+		if tr.Len() != 0 || len(got) != 1010 {
+			t.Fatalf("expected to visit 1010 items and empty the tree, got %d and %d", len(got), tr.Len())
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i] <= got[i-1] {
+				t.Fatalf("items out of order: %d then %d", got[i-1], got[i])
+			}
+		}
+		// Scan backward while deleting the item before the cursor.
+		for i := 0; i < 100; i++ {
+			tr.ReplaceOrInsert(Int(i))
+		}
+		got = got[:0]
+		for item := c.Last(); item != nilPair; item = c.Prev() {
+			got = append(got, PairInt(item))
+			tr.Delete(Int(PairInt(item) - 1))
+		}
+		var want []int
+		for i := 99; i > 0; i -= 2 {
+			want = append(want, i)
+		}
+		if !intsEqual(got, want) {
+			t.Fatalf("backward mismatch:\n got: %v\nwant: %v", got, want)
+		}
+		tr.Clear(false)
+	}
+	// Stepping off the end stays off the end.
+	tr := New(lessFn)
+	tr.ReplaceOrInsert(Int(1))
+	c := tr.StableCursor()
+	c.First()
+	if c.Next() != nilPair {
+		t.Fatal("expected end of tree")
+	}
+	tr.ReplaceOrInsert(Int(2))
+	if c.Next() != nilPair {
+		t.Fatal("expected cursor to remain at the end")
+	}
+}
+
+func TestCursorDeleteReplace(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+		for _, v := range rand.Perm(1000) {
+			tr.ReplaceOrInsert(intVal(v, "a"))
+		}
+		clone := tr.Clone()
+		c := tr.Cursor()
+		for item := c.First(); item != nilPair; {
+			if PairInt(item)%2 == 0 {
+				item = c.Delete()
+				continue
+			}
+			if old := c.Replace(intVal(PairInt(item), "b")); PairInt(old) != PairInt(item) {
+				t.Fatalf("replaced %v, expected %v", IntStr(old), IntStr(item))
+			}
+			item = c.Next()
+		}
+		checkTree(t, tr)
+		checkTree(t, clone)
+		if tr.Len() != 500 || clone.Len() != 1000 {
+			t.Fatalf("expected 500 and 1000 items, got %d and %d", tr.Len(), clone.Len())
+		}
+		tr.Ascend(func(item pair.Pair) bool {
+			if PairInt(item)%2 == 0 || string(item.Value()) != "b" {
+				t.Fatalf("unexpected item %v:%s", IntStr(item), item.Value())
+			}
+			return true
+		})
+		clone.Ascend(func(item pair.Pair) bool {
+			if string(item.Value()) != "a" {
+				t.Fatalf("clone was modified at %v", IntStr(item))
+			}
+			return true
+		})
+		if c.Delete() != nilPair || c.Replace(Int(1)) != nilPair {
+			t.Fatal("expected no item under the cursor")
+		}
+	}
+	tr := New(lessFn)
+	tr.ReplaceOrInsert(Int(1))
+	c := tr.Cursor()
+	c.First()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic replacing with a different key")
+		}
+	}()
+	c.Replace(Int(2))
+}
+
+func TestCursorEditAfterModify(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 200; run++ {
+		tr := NewWithOptions(Options{Degree: 2, Less: lessFn})
+		for _, v := range rng.Perm(100) {
+			tr.ReplaceOrInsert(intVal(v, "a"))
+		}
+		pivot := rng.Intn(100)
+		c, sc := tr.Cursor(), tr.StableCursor()
+		c.Seek(Int(pivot))
+		sc.Seek(Int(pivot))
+		// Edit the tree outside of the cursors, freeing and reusing nodes.
+		for i := 0; i < 20; i++ {
+			tr.Delete(Int(rng.Intn(100)))
+			tr.ReplaceOrInsert(intVal(rng.Intn(100), "a"))
+		}
+		want := treeInts(tr)
+		var edited pair.Pair
+		if run%2 == 0 {
+			edited = c.Replace(intVal(pivot, "b"))
+		} else {
+			edited = c.Delete()
+		}
+		if edited != nilPair || c.Err() != ErrModified {
+			t.Fatalf("run %d: expected no edit and ErrModified, got %v and %v",
+				run, IntStr(edited), c.Err())
+		}
+		checkTree(t, tr)
+		if got := treeInts(tr); !intsEqual(got, want) {
+			t.Fatalf("run %d: tree changed from %v to %v", run, want, got)
+		}
+		// A stable cursor edits its item, if it is still in the tree.
+		has := tr.Has(Int(pivot))
+		if old := sc.Replace(intVal(pivot, "b")); (old != nilPair) != has || sc.Err() != nil {
+			t.Fatalf("run %d: expected to replace %d: %v, got %v", run, pivot, has, IntStr(old))
+		}
+		if has && string(tr.Get(Int(pivot)).Value()) != "b" {
+			t.Fatalf("run %d: item %d was not replaced", run, pivot)
+		}
+		checkTree(t, tr)
+	}
+}
+
+func TestCursorRange(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+		for _, v := range rand.Perm(100) {
+			tr.ReplaceOrInsert(Int(v * 2))
+		}
+		for i := 0; i < 500; i++ {
+			lo, hi := rand.Intn(210)-5, rand.Intn(210)-5
+			loInc, hiInc := rand.Intn(2) == 0, rand.Intn(2) == 0
+			var want []int
+			for v := 0; v < 200; v += 2 {
+				if (v > lo || loInc && v == lo) && (v < hi || hiInc && v == hi) {
+					want = append(want, v)
+				}
+			}
+			c := tr.CursorRange(Int(lo), Int(hi), loInc, hiInc)
+			if lo < 0 {
+				// Int keys are unsigned, so use an open bound instead.
+				c = tr.CursorRange(nilPair, Int(hi), loInc, hiInc)
+				want = want[:0]
+				for v := 0; v < 200 && (v < hi || hiInc && v == hi); v += 2 {
+					want = append(want, v)
+				}
+			}
+			var got []int
+			for item := c.First(); item != nilPair; item = c.Next() {
+				got = append(got, PairInt(item))
+			}
+			if !intsEqual(got, want) {
+				t.Fatalf("[%d %v, %d %v] forward mismatch:\n got: %v\nwant: %v", lo, loInc, hi, hiInc, got, want)
+			}
+			got = got[:0]
+			for item := c.Last(); item != nilPair; item = c.Prev() {
+				got = append([]int{PairInt(item)}, got...)
+			}
+			if !intsEqual(got, want) {
+				t.Fatalf("[%d %v, %d %v] backward mismatch:\n got: %v\nwant: %v", lo, loInc, hi, hiInc, got, want)
+			}
+			// Seeking outside of the range clamps to the range.
+			pivot := rand.Intn(210)
+			for _, tc := range []struct {
+				name string
+				fn   func(pair.Pair) pair.Pair
+				ok   func(v int) bool
+				last bool
+			}{
+				{"Seek", c.Seek, func(v int) bool { return v >= pivot }, false},
+				{"SeekGT", c.SeekGT, func(v int) bool { return v > pivot }, false},
+				{"SeekLE", c.SeekLE, func(v int) bool { return v <= pivot }, true},
+				{"SeekLT", c.SeekLT, func(v int) bool { return v < pivot }, true},
+			} {
+				exp := "<nil>"
+				for j := range want {
+					if tc.last {
+						j = len(want) - 1 - j
+					}
+					if tc.ok(want[j]) {
+						exp = fmt.Sprint(want[j])
+						break
+					}
+				}
+				if got := IntStr(tc.fn(Int(pivot))); got != exp {
+					t.Fatalf("[%d %v, %d %v] %s(%d): expected %s, got %s", lo, loInc, hi, hiInc, tc.name, pivot, exp, got)
+				}
+			}
+		}
+	}
+}
+
+func TestCursorState(t *testing.T) {
+	tr := New(lessFn)
+	for i := 0; i < 100; i++ {
+		tr.ReplaceOrInsert(intVal(i, fmt.Sprint(i)))
+	}
+	c := tr.Cursor()
+	if c.Valid() || c.Item() != nilPair || c.Key() != nil || c.Value() != nil {
+		t.Fatal("expected an unpositioned cursor")
+	}
+	var n int
+	for c.First(); c.Valid(); c.Next() {
+		if PairInt(c.Item()) != n || PairInt(pair.New(c.Key(), nil)) != n || string(c.Value()) != fmt.Sprint(n) {
+			t.Fatalf("unexpected item %v:%s at %d", IntStr(c.Item()), c.Value(), n)
+		}
+		n++
+	}
+	if n != 100 || c.Err() != nil {
+		t.Fatalf("expected 100 items and no error, got %d and %v", n, c.Err())
+	}
+	for c.Last(); c.Valid(); c.Prev() {
+		n--
+	}
+	if n != 0 {
+		t.Fatalf("expected to visit 100 items backward, %d left", n)
+	}
+	c.Seek(Int(50))
+	c.Delete()
+	c.Next()
+	if c.Err() != nil {
+		t.Fatalf("unexpected error after deleting through the cursor: %v", c.Err())
+	}
+	tr.Delete(Int(60))
+	c.Next()
+	if c.Err() != ErrModified {
+		t.Fatalf("expected ErrModified, got %v", c.Err())
+	}
+	if c.First(); c.Err() != nil {
+		t.Fatalf("expected error to be cleared, got %v", c.Err())
+	}
+	s := tr.StableCursor()
+	s.First()
+	tr.Delete(Int(1))
+	if s.Next(); s.Err() != nil || PairInt(s.Item()) != 2 {
+		t.Fatalf("unexpected stable cursor state: %v, %v", IntStr(s.Item()), s.Err())
+	}
+}
+
+func TestCursorStepAfterModify(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 200; run++ {
+		tr := NewWithOptions(Options{Degree: 2, Less: lessFn})
+		for _, v := range rng.Perm(50) {
+			tr.ReplaceOrInsert(Int(v))
+		}
+		c := tr.Cursor()
+		pivot := PairInt(c.Seek(Int(rng.Intn(50))))
+		for i := 0; i < 10; i++ {
+			tr.Delete(Int(rng.Intn(50)))
+		}
+		// The cursor carries on from its item through the modified tree,
+		// reporting the modification.
+		want := treeInts(tr)
+		if run%2 == 0 {
+			var got []int
+			for item := c.Prev(); item != nilPair; item = c.Prev() {
+				got = append(got, PairInt(item))
+			}
+			var below []int
+			for _, v := range want {
+				if v < pivot {
+					below = append([]int{v}, below...)
+				}
+			}
+			if !intsEqual(got, below) {
+				t.Fatalf("run %d: expected %v, got %v", run, below, got)
+			}
+		} else {
+			var got []int
+			for item := c.Next(); item != nilPair; item = c.Next() {
+				got = append(got, PairInt(item))
+			}
+			var above []int
+			for _, v := range want {
+				if v > pivot {
+					above = append(above, v)
+				}
+			}
+			if !intsEqual(got, above) {
+				t.Fatalf("run %d: expected %v, got %v", run, above, got)
+			}
+		}
+		if c.Err() != ErrModified {
+			t.Fatalf("run %d: expected ErrModified, got %v", run, c.Err())
+		}
+		if c.Last(); c.Err() != nil || c.Valid() != (tr.Len() > 0) {
+			t.Fatalf("run %d: expected Last to reposition the cursor", run)
+		}
+	}
+}
+
+func TestCursorDeep(t *testing.T) {
+	// A degree 2 tree of this size is deep enough that walking off either
+	// end climbs many levels of the stack.
+	tr := NewWithOptions(Options{Degree: 2, Less: lessFn})
+	if err := tr.BulkLoad(rang(1 << 16)); err != nil {
+		t.Fatal(err)
+	}
+	c := tr.Cursor()
+	var n int
+	for c.First(); c.Valid(); c.Next() {
+		n++
+	}
+	if n != 1<<16 || c.Next() != nilPair || c.Prev() != nilPair {
+		t.Fatalf("expected %d items then the end, got %d", 1<<16, n)
+	}
+}
@@ -0,0 +1,75 @@
+package pairtree
+
+import (
+	"bytes"
+
+	"github.com/tidwall/pair"
+)
+
+// The prefix functions assume that the tree orders pairs by comparing their
+// keys with bytes.Compare, which is the default ordering.  On trees with any
+// other ordering the keys that share a prefix are not contiguous, and the
+// results are undefined.
+
+// prefixEnd returns the smallest key that is greater than every key with the
+// given prefix, or nil if there is no such key because the prefix is empty or
+// made up entirely of 0xFF bytes.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte(nil), prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// prefixRange returns the range [greaterOrEqual, lessThan) of the keys with
+// the given prefix.  lessThan is nil when the range is unbounded.
+func prefixRange(prefix []byte) (greaterOrEqual, lessThan pair.Pair) {
+	greaterOrEqual = pair.New(prefix, nil)
+	if end := prefixEnd(prefix); end != nil {
+		lessThan = pair.New(end, nil)
+	}
+	return greaterOrEqual, lessThan
+}
+
+// AscendPrefix calls the iterator for every pair in the tree whose key starts
+// with prefix, in ascending order, until iterator returns false.
+func (t *PairTree) AscendPrefix(prefix []byte, iterator func(item pair.Pair) bool) {
+	greaterOrEqual, lessThan := prefixRange(prefix)
+	t.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// DescendPrefix calls the iterator for every pair in the tree whose key
+// starts with prefix, in descending order, until iterator returns false.
+func (t *PairTree) DescendPrefix(prefix []byte, iterator func(item pair.Pair) bool) {
+	_, lessThan := prefixRange(prefix)
+	t.iterate(descend, bound(lessThan), empty[pair.Pair](), false, func(item pair.Pair) bool {
+		if !bytes.HasPrefix(item.Key(), prefix) {
+			return false
+		}
+		return iterator(item)
+	})
+}
+
+// CountPrefix returns the number of pairs in the tree whose key starts with
+// prefix.
+func (t *PairTree) CountPrefix(prefix []byte) int {
+	return t.CountRange(prefixRange(prefix))
+}
+
+// DeletePrefix removes all pairs in the tree whose key starts with prefix,
+// returning the number of pairs removed.
+func (t *PairTree) DeletePrefix(prefix []byte) int {
+	return t.DeleteRange(prefixRange(prefix))
+}
+
+// HasPrefix returns true if the tree contains a pair whose key starts with
+// prefix.
+func (t *PairTree) HasPrefix(prefix []byte) bool {
+	greaterOrEqual, _ := prefixRange(prefix)
+	item, ok := t.Ceiling(greaterOrEqual)
+	return ok && bytes.HasPrefix(item.Key(), prefix)
+}
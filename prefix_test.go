@@ -0,0 +1,89 @@
+package pairtree
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, want string
+	}{
+		{"", ""},
+		{"a", "b"},
+		{"user:", "user;"},
+		{"a\xff", "b"},
+		{"a\xfe\xff\xff", "a\xff"},
+		{"\xff\xff", ""},
+	} {
+		if got := prefixEnd([]byte(tc.prefix)); string(got) != tc.want {
+			t.Fatalf("prefixEnd(%q): expected %q, got %q", tc.prefix, tc.want, got)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	keys := []string{
+		"", "a", "user", "user:1", "user:1:name", "user:1:profile", "user:2",
+		"user:2:name", "user;", "users", "\xff", "\xff\xff", "\xff\xff\x00",
+		"\xff\xff\xff",
+	}
+	tr := New(nil)
+	for _, k := range keys {
+		tr.ReplaceOrInsert(pair.New([]byte(k), nil))
+	}
+	for _, prefix := range []string{"", "user", "user:", "user:1", "user:3", "\xff", "\xff\xff", "\xff\xff\xff\xff"} {
+		var want []string
+		for _, k := range keys {
+			if strings.HasPrefix(k, prefix) {
+				want = append(want, k)
+			}
+		}
+		sort.Strings(want)
+		var got []string
+		tr.AscendPrefix([]byte(prefix), func(item pair.Pair) bool {
+			got = append(got, string(item.Key()))
+			return true
+		})
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("ascend %q: expected %q, got %q", prefix, want, got)
+		}
+		got = got[:0]
+		tr.DescendPrefix([]byte(prefix), func(item pair.Pair) bool {
+			got = append([]string{string(item.Key())}, got...)
+			return true
+		})
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("descend %q: expected %q, got %q", prefix, want, got)
+		}
+		if n := tr.CountPrefix([]byte(prefix)); n != len(want) {
+			t.Fatalf("count %q: expected %d, got %d", prefix, len(want), n)
+		}
+		if has := tr.HasPrefix([]byte(prefix)); has != (len(want) > 0) {
+			t.Fatalf("has %q: expected %v", prefix, !has)
+		}
+		clone := tr.Clone()
+		if n := clone.DeletePrefix([]byte(prefix)); n != len(want) {
+			t.Fatalf("delete %q: expected %d, got %d", prefix, len(want), n)
+		}
+		checkTree(t, clone)
+		if clone.HasPrefix([]byte(prefix)) || clone.Len() != len(keys)-len(want) {
+			t.Fatalf("delete %q left %d items", prefix, clone.Len())
+		}
+	}
+	var count int
+	tr.DescendPrefix([]byte("user:"), func(item pair.Pair) bool {
+		if !bytes.Equal(item.Key(), []byte("user:2:name")) {
+			t.Fatalf("unexpected key %q", item.Key())
+		}
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Fatalf("expected descend to stop after 1 item, got %d", count)
+	}
+}
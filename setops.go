@@ -0,0 +1,92 @@
+package pairtree
+
+import (
+	"bytes"
+
+	"github.com/tidwall/pair"
+)
+
+// setOp identifies which items a merge walk keeps.
+type setOp int
+
+const (
+	opUnion setOp = iota
+	opIntersection
+	opDifference
+	opSymmetricDifference
+)
+
+// Union returns a new tree containing the items that are in a or b.
+//
+// When both trees contain equivalent items with different values, resolve is
+// called with the item from a and the item from b, and the item it returns is
+// kept.  A nil resolve keeps the item from b.
+func Union(a, b *PairTree, resolve func(a, b pair.Pair) pair.Pair) *PairTree {
+	if b.Len() == 0 {
+		return a.Clone()
+	}
+	return merge(a, b, opUnion, resolve)
+}
+
+// Intersection returns a new tree containing the items that are in both a and
+// b.
+//
+// When both trees contain equivalent items with different values, resolve is
+// called with the item from a and the item from b, and the item it returns is
+// kept.  A nil resolve keeps the item from b.
+func Intersection(a, b *PairTree, resolve func(a, b pair.Pair) pair.Pair) *PairTree {
+	return merge(a, b, opIntersection, resolve)
+}
+
+// Difference returns a new tree containing the items of a that are not in b.
+func Difference(a, b *PairTree) *PairTree {
+	if b.Len() == 0 {
+		return a.Clone()
+	}
+	return merge(a, b, opDifference, nil)
+}
+
+// SymmetricDifference returns a new tree containing the items that are in
+// either a or b, but not in both.
+func SymmetricDifference(a, b *PairTree) *PairTree {
+	return merge(a, b, opSymmetricDifference, nil)
+}
+
+// merge walks a and b side by side in sorted order, collecting the items
+// selected by op, and bulk loads them into a new tree that uses the degree,
+// less function and free list of a.  It runs in O(n+m) time.
+func merge(a, b *PairTree, op setOp, resolve func(a, b pair.Pair) pair.Pair) *PairTree {
+	var out []pair.Pair
+	ca, cb := a.Cursor(), b.Cursor()
+	ia, ib := ca.First(), cb.First()
+	for ia != nilPair || ib != nilPair {
+		switch {
+		case ib == nilPair || (ia != nilPair && a.less(ia, ib)):
+			if op != opIntersection {
+				out = append(out, ia)
+			}
+			ia = ca.Next()
+		case ia == nilPair || a.less(ib, ia):
+			if op == opUnion || op == opSymmetricDifference {
+				out = append(out, ib)
+			}
+			ib = cb.Next()
+		default:
+			if op == opUnion || op == opIntersection {
+				item := ia
+				if !bytes.Equal(ia.Value(), ib.Value()) {
+					if resolve != nil {
+						item = resolve(ia, ib)
+					} else {
+						item = ib
+					}
+				}
+				out = append(out, item)
+			}
+			ia, ib = ca.Next(), cb.Next()
+		}
+	}
+	t := newWithFreeList(a.degree, a.cow.freelist, a.ordering)
+	t.load(out)
+	return t
+}
@@ -0,0 +1,117 @@
+package pairtree
+
+import (
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+// intsTree returns a tree containing the given integers.
+func intsTree(vals ...int) *PairTree {
+	tr := NewWithOptions(Options{Degree: 2, Less: lessFn})
+	for _, v := range vals {
+		tr.ReplaceOrInsert(Int(v))
+	}
+	return tr
+}
+
+// treeInts returns the integers in the tree in order.
+func treeInts(tr *PairTree) (out []int) {
+	tr.Ascend(func(item pair.Pair) bool {
+		out = append(out, PairInt(item))
+		return true
+	})
+	return
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetOperations(t *testing.T) {
+	var evens, threes []int
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			evens = append(evens, i)
+		}
+		if i%3 == 0 {
+			threes = append(threes, i)
+		}
+	}
+	a, b := intsTree(evens...), intsTree(threes...)
+	var union, inter, diff, sym []int
+	for i := 0; i < 100; i++ {
+		inA, inB := i%2 == 0, i%3 == 0
+		if inA || inB {
+			union = append(union, i)
+		}
+		if inA && inB {
+			inter = append(inter, i)
+		}
+		if inA && !inB {
+			diff = append(diff, i)
+		}
+		if inA != inB {
+			sym = append(sym, i)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		tr   *PairTree
+		want []int
+	}{
+		{"union", Union(a, b, nil), union},
+		{"intersection", Intersection(a, b, nil), inter},
+		{"difference", Difference(a, b), diff},
+		{"symmetric difference", SymmetricDifference(a, b), sym},
+		{"union with empty", Union(a, intsTree(), nil), evens},
+		{"difference with empty", Difference(a, intsTree()), evens},
+		{"intersection with empty", Intersection(intsTree(), b, nil), nil},
+	} {
+		checkTree(t, tc.tr)
+		if got := treeInts(tc.tr); !intsEqual(got, tc.want) {
+			t.Fatalf("%s: mismatch:\n got: %v\nwant: %v", tc.name, got, tc.want)
+		}
+	}
+	if got := treeInts(a); !intsEqual(got, evens) {
+		t.Fatalf("a was modified")
+	}
+	if got := treeInts(b); !intsEqual(got, threes) {
+		t.Fatalf("b was modified")
+	}
+}
+
+func TestSetOperationsResolve(t *testing.T) {
+	a, b := New(nil), New(nil)
+	a.ReplaceOrInsert(pair.New([]byte("same"), []byte("1")))
+	b.ReplaceOrInsert(pair.New([]byte("same"), []byte("1")))
+	a.ReplaceOrInsert(pair.New([]byte("diff"), []byte("a")))
+	b.ReplaceOrInsert(pair.New([]byte("diff"), []byte("b")))
+	var calls int
+	resolve := func(x, y pair.Pair) pair.Pair {
+		calls++
+		return pair.New(x.Key(), append(x.Value(), y.Value()...))
+	}
+	for _, tr := range []*PairTree{Union(a, b, resolve), Intersection(a, b, resolve)} {
+		if tr.Len() != 2 {
+			t.Fatalf("expected 2 items, got %d", tr.Len())
+		}
+		if v := tr.Get(pair.New([]byte("diff"), nil)).Value(); string(v) != "ab" {
+			t.Fatalf("expected resolved value 'ab', got %q", v)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected resolve to be called twice, got %d", calls)
+	}
+	if v := Union(a, b, nil).Get(pair.New([]byte("diff"), nil)).Value(); string(v) != "b" {
+		t.Fatalf("expected value from b, got %q", v)
+	}
+}
@@ -0,0 +1,255 @@
+package pairtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/tidwall/pair"
+)
+
+// A snapshot is made up of a header, the pairs in ascending order and a
+// trailer:
+//
+//	magic    "PTRE"
+//	version  1 byte
+//	degree   uvarint
+//	count    uvarint
+//	pairs    count * (uvarint key length, key, uvarint value length, value)
+//	checksum 4 bytes, little endian CRC-32C of everything before it
+const (
+	snapshotMagic   = "PTRE"
+	snapshotVersion = 1
+
+	// maxSnapshotLength bounds the lengths and counts read from a snapshot.
+	maxSnapshotLength = 1 << 31
+
+	// snapshotChunk is the most that is allocated for a length read from a
+	// snapshot before the bytes it promises have arrived.
+	snapshotChunk = 1 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned when reading a snapshot, or opening a mapped file,
+// that is malformed or fails its checksum.
+var ErrCorrupt = errors.New("pairtree: corrupt snapshot")
+
+// WriteTo writes a snapshot of the tree to w, returning the number of bytes
+// written.  The snapshot holds the degree of the tree and the key and value
+// of each pair, and can be loaded with ReadFrom.
+func (t *PairTree) WriteTo(w io.Writer) (int64, error) {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.New(castagnoli)}
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion})
+	sw.uvarint(uint64(t.degree))
+	sw.uvarint(uint64(t.length))
+	t.Ascend(func(item pair.Pair) bool {
+		sw.bytes(item.Key())
+		sw.bytes(item.Value())
+		return sw.err == nil
+	})
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], sw.crc.Sum32())
+	sw.write(sum[:])
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.n, sw.err
+}
+
+// ReadFrom replaces the contents of the tree with a snapshot written by
+// WriteTo, returning the number of bytes read.  The tree takes on the degree
+// of the snapshot, and is built with BulkLoad in O(n) time.
+//
+// ErrCorrupt is returned if the snapshot is malformed or fails its checksum,
+// and ErrNotSorted if its pairs are not in order according to the less
+// function of the tree.  On error the tree is left untouched.  Reads are
+// buffered, so ReadFrom may read past the end of the snapshot.
+func (t *PairTree) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(castagnoli)}
+	err := t.readSnapshot(sr)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return sr.n, err
+}
+
+func (t *PairTree) readSnapshot(sr *snapshotReader) error {
+	var header [len(snapshotMagic) + 1]byte
+	if err := sr.readFull(header[:]); err != nil {
+		return err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrCorrupt
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return fmt.Errorf("pairtree: unsupported snapshot version %d", header[len(snapshotMagic)])
+	}
+	degree, err := sr.uvarint()
+	if err != nil {
+		return err
+	}
+	count, err := sr.uvarint()
+	if err != nil {
+		return err
+	}
+	if degree < 2 || degree > maxSnapshotLength || count > maxSnapshotLength {
+		return ErrCorrupt
+	}
+	capacity := count
+	if capacity > 1<<16 {
+		capacity = 1 << 16
+	}
+	sorted := make([]pair.Pair, 0, capacity)
+	var key, value []byte
+	for i := uint64(0); i < count; i++ {
+		if key, err = sr.bytes(key); err != nil {
+			return err
+		}
+		if value, err = sr.bytes(value); err != nil {
+			return err
+		}
+		sorted = append(sorted, pair.New(key, value))
+	}
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if err := sr.readFull(sum[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != want {
+		return ErrCorrupt
+	}
+	prev := t.degree
+	t.degree = int(degree)
+	if err := t.BulkLoad(sorted); err != nil {
+		t.degree = prev
+		return err
+	}
+	return nil
+}
+
+// snapshotWriter writes the parts of a snapshot, keeping the first error and
+// a checksum of everything written.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.crc.Write(p[:n])
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sw *snapshotWriter) uvarint(x uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], x)])
+}
+
+func (sw *snapshotWriter) uint32(x uint32) {
+	sw.write(binary.LittleEndian.AppendUint32(sw.buf[:0], x))
+}
+
+func (sw *snapshotWriter) uint64(x uint64) {
+	sw.write(binary.LittleEndian.AppendUint64(sw.buf[:0], x))
+}
+
+func (sw *snapshotWriter) bytes(p []byte) {
+	sw.uvarint(uint64(len(p)))
+	sw.write(p)
+}
+
+// snapshotReader reads the parts of a snapshot, keeping a checksum of
+// everything read.
+type snapshotReader struct {
+	r   byteReader
+	crc hash.Hash32
+	n   int64
+}
+
+// byteReader is a reader that can also read a byte at a time, as a
+// snapshotReader needs to read uvarints.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// unbufferedReader reads a byte at a time from a reader without reading
+// ahead, so that no more is consumed than a snapshotReader asks for.
+type unbufferedReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (r *unbufferedReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(r.Reader, r.buf[:]); err != nil {
+		return 0, err
+	}
+	return r.buf[0], nil
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	c, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{c})
+		sr.n++
+	}
+	return c, err
+}
+
+func (sr *snapshotReader) readFull(p []byte) error {
+	n, err := io.ReadFull(sr.r, p)
+	sr.crc.Write(p[:n])
+	sr.n += int64(n)
+	return err
+}
+
+func (sr *snapshotReader) uvarint() (uint64, error) {
+	x, err := binary.ReadUvarint(sr)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// The varint overflowed.
+		return 0, ErrCorrupt
+	}
+	return x, err
+}
+
+// bytes reads a length-prefixed byte slice, reusing buf when it is large
+// enough.
+func (sr *snapshotReader) bytes(buf []byte) ([]byte, error) {
+	n, err := sr.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotLength {
+		return nil, ErrCorrupt
+	}
+	if uint64(cap(buf)) >= n {
+		buf = buf[:n]
+		return buf, sr.readFull(buf)
+	}
+	// The length may be corrupt, so rather than allocating it all up front,
+	// grow the buffer in bounded chunks as the bytes arrive.
+	buf = buf[:0]
+	for uint64(len(buf)) < n {
+		chunk := n - uint64(len(buf))
+		if chunk > snapshotChunk {
+			chunk = snapshotChunk
+		}
+		buf = append(buf, make([]byte, chunk)...)
+		if err := sr.readFull(buf[uint64(len(buf))-chunk:]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,109 @@
+package pairtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+func TestSnapshot(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 5000} {
+		tr := NewWithOptions(Options{Degree: 3, Less: lessFn})
+		for _, v := range rand.Perm(n) {
+			tr.ReplaceOrInsert(intVal(v, fmt.Sprint(v)))
+		}
+		var buf bytes.Buffer
+		written, err := tr.WriteTo(&buf)
+		if err != nil || written != int64(buf.Len()) {
+			t.Fatalf("write: %v, wrote %d of %d bytes", err, written, buf.Len())
+		}
+		out := New(lessFn)
+		out.ReplaceOrInsert(Int(-1))
+		read, err := out.ReadFrom(bytes.NewReader(buf.Bytes()))
+		if err != nil || read != written {
+			t.Fatalf("read: %v, read %d of %d bytes", err, read, written)
+		}
+		checkTree(t, out)
+		if out.degree != 3 || out.Len() != n {
+			t.Fatalf("expected degree 3 and %d items, got %d and %d", n, out.degree, out.Len())
+		}
+		i := 0
+		out.Ascend(func(item pair.Pair) bool {
+			if PairInt(item) != i || string(item.Value()) != fmt.Sprint(i) {
+				t.Fatalf("unexpected item %v:%s at %d", IntStr(item), item.Value(), i)
+			}
+			i++
+			return true
+		})
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	tr := New(lessFn)
+	for i := 0; i < 100; i++ {
+		tr.ReplaceOrInsert(intVal(i, "value"))
+	}
+	var buf bytes.Buffer
+	if _, err := tr.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := New(lessFn)
+	out.ReplaceOrInsert(Int(7))
+	for i := 0; i < len(data); i += 1 + i/8 {
+		bad := append([]byte(nil), data...)
+		bad[i] ^= 0x40
+		if _, err := out.ReadFrom(bytes.NewReader(bad)); err == nil {
+			t.Fatalf("expected error with byte %d flipped", i)
+		}
+		if _, err := out.ReadFrom(bytes.NewReader(data[:i])); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected unexpected EOF truncated to %d bytes, got %v", i, err)
+		}
+	}
+	if out.Len() != 1 || !out.Has(Int(7)) {
+		t.Fatalf("tree was modified by a failed read")
+	}
+	// A huge corrupt length is not allocated up front.
+	huge := append([]byte(snapshotMagic), snapshotVersion, 2, 1)
+	huge = binary.AppendUvarint(huge, maxSnapshotLength-1)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := out.ReadFrom(bytes.NewReader(huge)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF for a huge length, got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 4*snapshotChunk {
+		t.Fatalf("allocated %d bytes reading a %d byte snapshot", alloc, len(huge))
+	}
+	// A snapshot in the wrong order for the tree is rejected.
+	if _, err := New(func(a, b pair.Pair) bool {
+		return lessFn(b, a)
+	}).ReadFrom(bytes.NewReader(data)); !errors.Is(err, ErrNotSorted) {
+		t.Fatalf("expected ErrNotSorted, got %v", err)
+	}
+}
+
+func BenchmarkReadFrom(b *testing.B) {
+	tr := New(lessFn)
+	if err := tr.BulkLoad(rang(benchmarkTreeSize * 10)); err != nil {
+		b.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := tr.WriteTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+	out := New(lessFn)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := out.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
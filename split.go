@@ -0,0 +1,281 @@
+package pairtree
+
+import "github.com/tidwall/pair"
+
+// height returns the number of levels in the subtree rooted at n.
+func (n *nodeG[T]) height() int {
+	h := 1
+	for len(n.children) > 0 {
+		n = n.children[0]
+		h++
+	}
+	return h
+}
+
+// subtree returns a tree that shares the configuration and write context of t
+// and is rooted at n.
+func (t *PairTree) subtree(n *node) *PairTree {
+	st := &PairTree{btree[pair.Pair]{degree: t.degree, root: n, cow: t.cow, ordering: t.ordering}}
+	if n != nil {
+		st.length = n.count
+	}
+	return st
+}
+
+// partial returns a new node holding the given items and children, which are
+// a slice of another node of height h, along with the height of the returned
+// node.  The returned node may have fewer than minPairs items and is only
+// suitable as the root of a tree.
+func (t *PairTree) partial(items items, children children, h int) (*node, int) {
+	if len(items) == 0 {
+		if len(children) == 1 {
+			return children[0], h - 1
+		}
+		return nil, 0
+	}
+	n := t.cow.newNode()
+	n.items = append(n.items, items...)
+	n.children = append(n.children, children...)
+	n.count = len(items)
+	for _, c := range children {
+		n.count += c.count
+	}
+	return n, h
+}
+
+// splitNode splits the tree rooted at n, of height h, into two trees, the
+// first containing all items less than key and the second containing all the
+// other items, and returns their roots and heights.  Either may be nil if
+// empty.  Nodes that are not owned by t are never modified.
+//
+// Heights are passed down and returned rather than measured, since a join
+// costs time in proportion to the difference in the heights of its trees,
+// and these differences sum to O(log n) along the path.
+func (t *PairTree) splitNode(n *node, h int, key pair.Pair) (left *node, hl int, right *node, hr int) {
+	i, found := n.items.find(key, &t.ordering)
+	switch {
+	case len(n.children) == 0:
+		left, hl = t.partial(n.items[:i], nil, h)
+		right, hr = t.partial(n.items[i:], nil, h)
+	case found:
+		left, hl = t.partial(n.items[:i], n.children[:i+1], h)
+		rest, hrest := t.partial(n.items[i+1:], n.children[i+1:], h)
+		right, hr = t.join(nil, 0, n.items[i], rest, hrest)
+	default:
+		left, hl, right, hr = t.splitNode(n.children[i], h-1, key)
+		if i > 0 {
+			rest, hrest := t.partial(n.items[:i-1], n.children[:i], h)
+			left, hl = t.join(rest, hrest, n.items[i-1], left, hl)
+		}
+		if i < len(n.items) {
+			rest, hrest := t.partial(n.items[i+1:], n.children[i+1:], h)
+			right, hr = t.join(right, hr, n.items[i], rest, hrest)
+		}
+	}
+	t.cow.freeNode(n)
+	return left, hl, right, hr
+}
+
+// join concatenates the trees rooted at left and right, of heights hl and hr,
+// using sep as the separator between them, and returns the root and height
+// of the result.  All items in left must be less than sep, and all items in
+// right must be greater than sep.  Either tree may be nil.
+func (t *PairTree) join(left *node, hl int, sep pair.Pair, right *node, hr int) (*node, int) {
+	if left == nil || left.count == 0 {
+		return t.insertEdge(right, hr, sep)
+	}
+	if right == nil || right.count == 0 {
+		return t.insertEdge(left, hl, sep)
+	}
+	var n, extra *node
+	var up pair.Pair
+	h := hl
+	if hl >= hr {
+		n, up, extra = t.joinRight(left, hl, sep, right, hr)
+	} else {
+		n, up, extra = t.joinLeft(left, hl, sep, right, hr)
+		h = hr
+	}
+	if extra == nil {
+		return n, h
+	}
+	root := t.cow.newNode()
+	root.items = append(root.items, up)
+	root.children = append(root.children, n, extra)
+	root.count = n.count + extra.count + 1
+	return root, h + 1
+}
+
+// insertEdge inserts item, which is less or greater than all the items in
+// the tree rooted at n, of height h, returning the root and height of the
+// result.
+func (t *PairTree) insertEdge(n *node, h int, item pair.Pair) (*node, int) {
+	if n == nil || n.count == 0 {
+		h = 1
+	} else if len(n.items) >= t.maxPairs() {
+		// The root will be split.
+		h++
+	}
+	st := t.subtree(n)
+	st.ReplaceOrInsert(item)
+	return st.root, h
+}
+
+// join2 concatenates the trees rooted at left and right, where all items in
+// left must be less than all items in right.  Either tree may be nil.
+func (t *PairTree) join2(left, right *node) *node {
+	if left == nil || left.count == 0 {
+		return right
+	}
+	if right == nil || right.count == 0 {
+		return left
+	}
+	st := t.subtree(left)
+	sep := st.DeleteMax()
+	var hl int
+	if st.root != nil && st.root.count > 0 {
+		hl = st.root.height()
+	}
+	n, _ := t.join(st.root, hl, sep, right, right.height())
+	return n
+}
+
+// joinRight joins right, of height hr, into the right spine of left, of
+// height hl >= hr.  Should the resulting node overflow, it is split and the
+// separator and new right sibling are returned for the caller to absorb.
+func (t *PairTree) joinRight(left *node, hl int, sep pair.Pair, right *node, hr int) (*node, pair.Pair, *node) {
+	if hl == hr {
+		return t.joinLevel(left, sep, right)
+	}
+	added := right.count + 1
+	left = left.mutableFor(t.cow)
+	last := len(left.children) - 1
+	c, up, extra := t.joinRight(left.children[last], hl-1, sep, right, hr)
+	left.children[last] = c
+	left.count += added
+	if extra != nil {
+		left.items = append(left.items, up)
+		left.children = append(left.children, extra)
+	}
+	return t.maybeSplit(left)
+}
+
+// joinLeft joins left, of height hl, into the left spine of right, of height
+// hr > hl.  Should the resulting node overflow, it is split and the separator
+// and new right sibling are returned for the caller to absorb.
+func (t *PairTree) joinLeft(left *node, hl int, sep pair.Pair, right *node, hr int) (*node, pair.Pair, *node) {
+	if hl == hr {
+		return t.joinLevel(left, sep, right)
+	}
+	added := left.count + 1
+	right = right.mutableFor(t.cow)
+	c, up, extra := t.joinLeft(left, hl, sep, right.children[0], hr-1)
+	right.children[0] = c
+	right.count += added
+	if extra != nil {
+		right.items.insertAt(0, up)
+		right.children.insertAt(1, extra)
+	}
+	return t.maybeSplit(right)
+}
+
+// joinLevel merges two nodes of the same height and their separator into a
+// single node, splitting it should it overflow.
+func (t *PairTree) joinLevel(left *node, sep pair.Pair, right *node) (*node, pair.Pair, *node) {
+	left = left.mutableFor(t.cow)
+	left.items = append(left.items, sep)
+	left.items = append(left.items, right.items...)
+	left.children = append(left.children, right.children...)
+	left.count += right.count + 1
+	t.cow.freeNode(right)
+	return t.maybeSplit(left)
+}
+
+// maybeSplit splits n in half if it has more than maxPairs items.
+func (t *PairTree) maybeSplit(n *node) (*node, pair.Pair, *node) {
+	if len(n.items) <= t.maxPairs() {
+		return n, nilPair, nil
+	}
+	up, extra := n.split(len(n.items) / 2)
+	return n, up, extra
+}
+
+// DeleteRange removes all items in the tree within the range
+// [greaterOrEqual, lessThan), returning the number of items removed.
+//
+// Rather than removing items one at a time, the tree is cut at both ends of
+// the range and the remaining edges are joined back together, so subtrees
+// that are entirely within the range are detached wholesale.
+func (t *PairTree) DeleteRange(greaterOrEqual, lessThan pair.Pair) int {
+	if t.root == nil || t.root.count == 0 {
+		return 0
+	}
+	if greaterOrEqual != nilPair && lessThan != nilPair && !t.less(greaterOrEqual, lessThan) {
+		return 0
+	}
+	var left, right *node
+	mid := t.root
+	h := mid.height()
+	if greaterOrEqual != nilPair {
+		left, _, mid, h = t.splitNode(mid, h, greaterOrEqual)
+	}
+	if lessThan != nilPair && mid != nil {
+		mid, _, right, _ = t.splitNode(mid, h, lessThan)
+	}
+	var removed int
+	if mid != nil {
+		removed = mid.count
+	}
+	t.root = t.join2(left, right)
+	t.length -= removed
+	t.mods++
+	return removed
+}
+
+// SplitAt splits the tree at key, returning a tree containing all items less
+// than key and a tree containing all other items.
+//
+// The tree is cut along the search path for key, so SplitAt runs in
+// O(log n) time.  Both returned trees lazily share nodes with t, which remains
+// unchanged and can continue to be used.
+func (t *PairTree) SplitAt(key pair.Pair) (left, right *PairTree) {
+	left = t.Clone()
+	right = &PairTree{btree[pair.Pair]{
+		degree:   t.degree,
+		cow:      &copyOnWriteContext{freelist: left.cow.freelist},
+		ordering: t.ordering,
+	}}
+	if left.root == nil || left.root.count == 0 {
+		return left, right
+	}
+	left.root, _, right.root, _ = left.splitNode(left.root, left.root.height(), key)
+	if right.root != nil {
+		right.length = right.root.count
+	}
+	left.length -= right.length
+	return left, right
+}
+
+// Join returns a tree containing all items of left followed by all items of
+// right.  All items in left must be less than all items in right, and both
+// trees must have the same degree, otherwise Join panics.  The returned tree
+// uses the less function and free list of left.
+//
+// The trees are concatenated along their spines, so Join runs in O(log n)
+// time.  The returned tree lazily shares nodes with left and right, which
+// remain unchanged and can continue to be used.
+func Join(left, right *PairTree) *PairTree {
+	if left.degree != right.degree {
+		panic("joined trees must have the same degree")
+	}
+	if left.Len() > 0 && right.Len() > 0 && !left.less(left.Max(), right.Min()) {
+		panic("joined trees must be ordered")
+	}
+	out := left.Clone()
+	// Cloning right marks its nodes read-only, so it will not modify them
+	// underneath the joined tree.
+	right.Clone()
+	out.root = out.join2(out.root, right.root)
+	out.length += right.length
+	return out
+}
@@ -0,0 +1,177 @@
+package pairtree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		for i := 0; i < 200; i++ {
+			n := rand.Intn(1000)
+			lo, hi := rand.Intn(n+10)-5, rand.Intn(n+10)-5
+			tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+			for _, item := range perm(n) {
+				tr.ReplaceOrInsert(item)
+			}
+			clone := tr.Clone()
+			want := all(tr)[:0:0]
+			for _, item := range rang(n) {
+				if v := PairInt(item); v < lo || v >= hi {
+					want = append(want, item)
+				}
+			}
+			removed := tr.DeleteRange(Int(lo), Int(hi))
+			if removed != n-len(want) {
+				t.Fatalf("DeleteRange(%d, %d) on %d items: expected %d removed, got %d",
+					lo, hi, n, n-len(want), removed)
+			}
+			checkTree(t, tr)
+			if got := all(tr); !IntDeepEqual(got, want) {
+				t.Fatalf("DeleteRange(%d, %d): mismatch:\n got: %v\nwant: %v", lo, hi, got, want)
+			}
+			checkTree(t, clone)
+			if got := all(clone); !IntDeepEqual(got, rang(n)) {
+				t.Fatalf("clone was modified")
+			}
+			for _, item := range perm(n) {
+				tr.ReplaceOrInsert(item)
+			}
+			checkTree(t, tr)
+			if got := all(tr); !IntDeepEqual(got, rang(n)) {
+				t.Fatalf("reinsert: mismatch:\n got: %v\nwant: %v", got, rang(n))
+			}
+		}
+	}
+}
+
+func TestDeleteRangeUnbounded(t *testing.T) {
+	tr := New(lessFn)
+	for _, item := range perm(100) {
+		tr.ReplaceOrInsert(item)
+	}
+	if removed := tr.DeleteRange(nilPair, Int(10)); removed != 10 {
+		t.Fatalf("expected 10 removed, got %d", removed)
+	}
+	if removed := tr.DeleteRange(Int(90), nilPair); removed != 10 {
+		t.Fatalf("expected 10 removed, got %d", removed)
+	}
+	checkTree(t, tr)
+	if got, want := all(tr), rang(90)[10:]; !IntDeepEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	if removed := tr.DeleteRange(nilPair, nilPair); removed != 80 {
+		t.Fatalf("expected 80 removed, got %d", removed)
+	}
+	checkTree(t, tr)
+	if tr.Len() != 0 || tr.Min() != nilPair {
+		t.Fatalf("expected empty tree")
+	}
+}
+
+func BenchmarkDeleteRange(b *testing.B) {
+	items := rang(benchmarkTreeSize)
+	tr := New(lessFn)
+	if err := tr.BulkLoad(items); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr2 := tr.Clone()
+		tr2.DeleteRange(items[100], items[len(items)-100])
+	}
+}
+
+func TestSplitAtJoin(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		for i := 0; i < 200; i++ {
+			n := rand.Intn(1000)
+			key := rand.Intn(n+10) - 5
+			tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+			for _, item := range perm(n) {
+				tr.ReplaceOrInsert(item)
+			}
+			left, right := tr.SplitAt(Int(key))
+			checkTree(t, tr)
+			checkTree(t, left)
+			checkTree(t, right)
+			if got, want := all(tr), rang(n); !IntDeepEqual(got, want) {
+				t.Fatalf("original was modified")
+			}
+			mid := key
+			if mid < 0 {
+				mid = 0
+			} else if mid > n {
+				mid = n
+			}
+			if got, want := all(left), rang(n)[:mid]; !IntDeepEqual(got, want) {
+				t.Fatalf("SplitAt(%d) left: mismatch:\n got: %v\nwant: %v", key, got, want)
+			}
+			if got, want := all(right), rang(n)[mid:]; !IntDeepEqual(got, want) {
+				t.Fatalf("SplitAt(%d) right: mismatch:\n got: %v\nwant: %v", key, got, want)
+			}
+			joined := Join(left, right)
+			checkTree(t, joined)
+			if got, want := all(joined), rang(n); !IntDeepEqual(got, want) {
+				t.Fatalf("Join: mismatch:\n got: %v\nwant: %v", got, want)
+			}
+			// Mutating any of the trees must not affect the others.
+			left.ReplaceOrInsert(Int(n + 1))
+			right.DeleteMin()
+			for _, item := range perm(n) {
+				joined.Delete(item)
+			}
+			checkTree(t, left)
+			checkTree(t, right)
+			checkTree(t, joined)
+			wantRight := n - mid
+			if wantRight > 0 {
+				wantRight--
+			}
+			if left.Len() != mid+1 || right.Len() != wantRight || joined.Len() != 0 {
+				t.Fatalf("unexpected lengths %d, %d, %d", left.Len(), right.Len(), joined.Len())
+			}
+			if got, want := all(tr), rang(n); !IntDeepEqual(got, want) {
+				t.Fatalf("original was modified")
+			}
+		}
+	}
+}
+
+func TestJoinUnordered(t *testing.T) {
+	left, right := New(lessFn), New(lessFn)
+	left.ReplaceOrInsert(Int(5))
+	right.ReplaceOrInsert(Int(5))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	Join(left, right)
+}
+
+func TestSplitNodeHeights(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		for i := 0; i < 200; i++ {
+			n := rand.Intn(1000) + 1
+			tr := NewWithOptions(Options{Degree: degree, Less: lessFn})
+			for _, item := range perm(n) {
+				tr.ReplaceOrInsert(item)
+			}
+			st := tr.Clone()
+			left, hl, right, hr := st.splitNode(st.root, st.root.height(), Int(rand.Intn(n+10)-5))
+			for _, side := range []struct {
+				n *node
+				h int
+			}{{left, hl}, {right, hr}} {
+				want := 0
+				if side.n != nil {
+					want = side.n.height()
+				}
+				if side.h != want {
+					t.Fatalf("degree %d, %d items: returned height %d, actual %d", degree, n, side.h, want)
+				}
+			}
+		}
+	}
+}
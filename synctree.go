@@ -0,0 +1,301 @@
+package pairtree
+
+import (
+	"sync"
+
+	"github.com/tidwall/pair"
+)
+
+// SyncTree is a PairTree that is safe for concurrent use by multiple
+// goroutines.  Reads share a read lock and writes take the write lock.
+//
+// The iteration methods hold the read lock until the iterator returns false
+// or the iteration ends, blocking writers in the meantime, and the iterator
+// must not call methods that write to the tree.  Long scans should instead
+// iterate over a Snapshot, which never blocks writers.
+type SyncTree struct {
+	mu sync.RWMutex
+	tr *PairTree
+}
+
+// NewSync returns a SyncTree that wraps tr.  The caller must not use tr
+// directly afterwards.
+func NewSync(tr *PairTree) *SyncTree {
+	return &SyncTree{tr: tr}
+}
+
+// Snapshot returns a copy of the tree as it is now.  The copy is created
+// lazily with Clone in O(1) time, and shares its nodes with the SyncTree
+// until either of them is modified, so the snapshot is not affected by later
+// writes to the SyncTree.
+//
+// The snapshot is owned by the caller.  Like any PairTree it is safe for
+// concurrent reads, as long as nothing writes to it.
+func (t *SyncTree) Snapshot() *PairTree {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.Clone()
+}
+
+// Clone clones the tree, lazily, into a new SyncTree.
+func (t *SyncTree) Clone() *SyncTree {
+	return NewSync(t.Snapshot())
+}
+
+// ReplaceOrInsert adds the given item to the tree.  See
+// PairTree.ReplaceOrInsert.
+func (t *SyncTree) ReplaceOrInsert(item pair.Pair) pair.Pair {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.ReplaceOrInsert(item)
+}
+
+// Delete removes an item equal to the passed in item from the tree, returning
+// it.  If no such item exists, returns nil.
+func (t *SyncTree) Delete(item pair.Pair) pair.Pair {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.Delete(item)
+}
+
+// DeleteMin removes the smallest item in the tree and returns it.
+// If no such item exists, returns nil.
+func (t *SyncTree) DeleteMin() pair.Pair {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.DeleteMin()
+}
+
+// DeleteMax removes the largest item in the tree and returns it.
+// If no such item exists, returns nil.
+func (t *SyncTree) DeleteMax() pair.Pair {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.DeleteMax()
+}
+
+// DeleteAt removes the item at index i in the sorted order of the tree,
+// returning it.  If i is out of range, returns nil.
+func (t *SyncTree) DeleteAt(i int) pair.Pair {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.DeleteAt(i)
+}
+
+// DeleteRange removes all items in the tree within the range
+// [greaterOrEqual, lessThan), returning the number of items removed.
+func (t *SyncTree) DeleteRange(greaterOrEqual, lessThan pair.Pair) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.DeleteRange(greaterOrEqual, lessThan)
+}
+
+// DeletePrefix removes all pairs in the tree whose key starts with prefix,
+// returning the number of pairs removed.
+func (t *SyncTree) DeletePrefix(prefix []byte) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.DeletePrefix(prefix)
+}
+
+// BulkLoad replaces the contents of the tree with the given sorted pairs.
+// See PairTree.BulkLoad.
+func (t *SyncTree) BulkLoad(sorted []pair.Pair) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tr.BulkLoad(sorted)
+}
+
+// Clear removes all items from the tree.  See PairTree.Clear.
+func (t *SyncTree) Clear(addNodesToFreelist bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tr.Clear(addNodesToFreelist)
+}
+
+// Get looks for the key item in the tree, returning it.  It returns nil if
+// unable to find that item.
+func (t *SyncTree) Get(key pair.Pair) pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Get(key)
+}
+
+// Has returns true if the given key is in the tree.
+func (t *SyncTree) Has(key pair.Pair) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Has(key)
+}
+
+// HasPrefix returns true if the tree contains a pair whose key starts with
+// prefix.
+func (t *SyncTree) HasPrefix(prefix []byte) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.HasPrefix(prefix)
+}
+
+// Min returns the smallest item in the tree, or nil if the tree is empty.
+func (t *SyncTree) Min() pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Min()
+}
+
+// Max returns the largest item in the tree, or nil if the tree is empty.
+func (t *SyncTree) Max() pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Max()
+}
+
+// Len returns the number of items currently in the tree.
+func (t *SyncTree) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Len()
+}
+
+// GetAt returns the item at index i in the sorted order of the tree.  It
+// returns nil if i is out of range.
+func (t *SyncTree) GetAt(i int) pair.Pair {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.GetAt(i)
+}
+
+// Rank returns the number of items in the tree that are less than key.
+func (t *SyncTree) Rank(key pair.Pair) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Rank(key)
+}
+
+// CountRange returns the number of items in the tree within the range
+// [greaterOrEqual, lessThan).
+func (t *SyncTree) CountRange(greaterOrEqual, lessThan pair.Pair) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.CountRange(greaterOrEqual, lessThan)
+}
+
+// CountPrefix returns the number of pairs in the tree whose key starts with
+// prefix.
+func (t *SyncTree) CountPrefix(prefix []byte) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.CountPrefix(prefix)
+}
+
+// Floor returns the greatest item in the tree that is less than or equal to
+// key, and whether such an item exists.
+func (t *SyncTree) Floor(key pair.Pair) (pair.Pair, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Floor(key)
+}
+
+// Ceiling returns the least item in the tree that is greater than or equal
+// to key, and whether such an item exists.
+func (t *SyncTree) Ceiling(key pair.Pair) (pair.Pair, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Ceiling(key)
+}
+
+// Lower returns the greatest item in the tree that is strictly less than key,
+// and whether such an item exists.
+func (t *SyncTree) Lower(key pair.Pair) (pair.Pair, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Lower(key)
+}
+
+// Higher returns the least item in the tree that is strictly greater than
+// key, and whether such an item exists.
+func (t *SyncTree) Higher(key pair.Pair) (pair.Pair, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tr.Higher(key)
+}
+
+// AscendRange calls the iterator for every value in the tree within the range
+// [greaterOrEqual, lessThan), until iterator returns false.
+func (t *SyncTree) AscendRange(greaterOrEqual, lessThan pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// AscendLessThan calls the iterator for every value in the tree within the range
+// [first, pivot), until iterator returns false.
+func (t *SyncTree) AscendLessThan(pivot pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.AscendLessThan(pivot, iterator)
+}
+
+// AscendGreaterOrEqual calls the iterator for every value in the tree within
+// the range [pivot, last], until iterator returns false.
+func (t *SyncTree) AscendGreaterOrEqual(pivot pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.AscendGreaterOrEqual(pivot, iterator)
+}
+
+// Ascend calls the iterator for every value in the tree within the range
+// [first, last], until iterator returns false.
+func (t *SyncTree) Ascend(iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.Ascend(iterator)
+}
+
+// AscendPrefix calls the iterator for every pair in the tree whose key starts
+// with prefix, in ascending order, until iterator returns false.
+func (t *SyncTree) AscendPrefix(prefix []byte, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.AscendPrefix(prefix, iterator)
+}
+
+// DescendRange calls the iterator for every value in the tree within the range
+// [lessOrEqual, greaterThan), until iterator returns false.
+func (t *SyncTree) DescendRange(lessOrEqual, greaterThan pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.DescendRange(lessOrEqual, greaterThan, iterator)
+}
+
+// DescendLessOrEqual calls the iterator for every value in the tree within the range
+// [pivot, first], until iterator returns false.
+func (t *SyncTree) DescendLessOrEqual(pivot pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.DescendLessOrEqual(pivot, iterator)
+}
+
+// DescendGreaterThan calls the iterator for every value in the tree within
+// the range (pivot, last], until iterator returns false.
+func (t *SyncTree) DescendGreaterThan(pivot pair.Pair, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.DescendGreaterThan(pivot, iterator)
+}
+
+// Descend calls the iterator for every value in the tree within the range
+// [last, first], until iterator returns false.
+func (t *SyncTree) Descend(iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.Descend(iterator)
+}
+
+// DescendPrefix calls the iterator for every pair in the tree whose key
+// starts with prefix, in descending order, until iterator returns false.
+func (t *SyncTree) DescendPrefix(prefix []byte, iterator func(item pair.Pair) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.tr.DescendPrefix(prefix, iterator)
+}
@@ -0,0 +1,62 @@
+package pairtree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/tidwall/pair"
+)
+
+func TestSyncTree(t *testing.T) {
+	tr := NewSync(New(lessFn))
+	const writers, perWriter = 4, 500
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				tr.ReplaceOrInsert(Int(w*perWriter + i))
+				if i%2 == 1 {
+					tr.Delete(Int(w*perWriter + i))
+				}
+			}
+		}(w)
+	}
+	// Readers scan snapshots while the writers run.  Every snapshot must be
+	// a consistent, sorted tree.
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				snap := tr.Snapshot()
+				n, prev := 0, -1
+				snap.Ascend(func(item pair.Pair) bool {
+					if v := PairInt(item); v <= prev {
+						t.Errorf("snapshot out of order: %d after %d", v, prev)
+					} else {
+						prev = v
+					}
+					n++
+					return true
+				})
+				if n != snap.Len() {
+					t.Errorf("snapshot has %d items, length is %d", n, snap.Len())
+				}
+				tr.Has(Int(i))
+				tr.CountRange(Int(0), Int(100))
+			}
+		}()
+	}
+	wg.Wait()
+	if tr.Len() != writers*perWriter/2 {
+		t.Fatalf("expected %d items, got %d", writers*perWriter/2, tr.Len())
+	}
+	snap := tr.Snapshot()
+	tr.DeleteRange(nilPair, nilPair)
+	if tr.Len() != 0 || snap.Len() != writers*perWriter/2 {
+		t.Fatalf("snapshot was modified by a write to the tree")
+	}
+	checkTree(t, snap)
+}
@@ -0,0 +1,355 @@
+package pairtree
+
+// entry is a key/value item stored in a TreeG.
+type entry[K, V any] struct {
+	key   K
+	value V
+}
+
+// TreeG is a B-Tree that maps keys of type K to values of type V.
+//
+// It shares its node, copy-on-write and cursor implementation with PairTree,
+// but stores typed keys and values directly, avoiding the allocation and byte
+// encoding needed to turn them into pairs.
+//
+// Write operations are not safe for concurrent mutation by multiple
+// goroutines, but Read operations are.
+type TreeG[K, V any] struct {
+	btree[entry[K, V]]
+}
+
+// NewG creates a new B-Tree of the given degree whose keys are ordered by cmp,
+// which returns a negative number when a < b, zero when a == b and a positive
+// number when a > b.  A degree of zero uses the default degree.
+//
+// It panics if degree is not zero and is less than 2, or if cmp is nil.
+func NewG[K, V any](degree int, cmp func(a, b K) int) *TreeG[K, V] {
+	if degree == 0 {
+		degree = defaultDegrees
+	} else if degree < 2 {
+		panic("bad degree")
+	}
+	if cmp == nil {
+		panic("nil compare function")
+	}
+	compare := func(a, b entry[K, V]) int {
+		return cmp(a.key, b.key)
+	}
+	return &TreeG[K, V]{btree[entry[K, V]]{
+		degree: degree,
+		cow: &copyOnWriteContextG[entry[K, V]]{
+			freelist: newFreeList[entry[K, V]](defaultFreeListSize),
+		},
+		ordering: ordering[entry[K, V]]{less: lessOf(compare), cmp: compare},
+	}}
+}
+
+// Clone clones the tree, lazily.  See PairTree.Clone for details.
+func (t *TreeG[K, V]) Clone() *TreeG[K, V] {
+	return &TreeG[K, V]{t.clone()}
+}
+
+// Clear removes all items from the tree.  See PairTree.Clear for details.
+func (t *TreeG[K, V]) Clear(addNodesToFreelist bool) {
+	t.clear(addNodesToFreelist)
+}
+
+// Set sets the value for key, returning the previous value and true if the
+// key was already in the tree.
+func (t *TreeG[K, V]) Set(key K, value V) (prev V, replaced bool) {
+	old, replaced := t.replaceOrInsert(entry[K, V]{key, value})
+	return old.value, replaced
+}
+
+// Get returns the value for key, and whether the key is in the tree.
+func (t *TreeG[K, V]) Get(key K) (V, bool) {
+	e, ok := t.get(entry[K, V]{key: key})
+	return e.value, ok
+}
+
+// Has returns true if the given key is in the tree.
+func (t *TreeG[K, V]) Has(key K) bool {
+	_, ok := t.get(entry[K, V]{key: key})
+	return ok
+}
+
+// Delete removes key from the tree, returning its value and whether it was
+// in the tree.
+func (t *TreeG[K, V]) Delete(key K) (V, bool) {
+	e, ok := t.deletePair(entry[K, V]{key: key}, removePair)
+	return e.value, ok
+}
+
+// DeleteMin removes the smallest key in the tree and returns it with its
+// value.  ok is false if the tree is empty.
+func (t *TreeG[K, V]) DeleteMin() (key K, value V, ok bool) {
+	e, ok := t.deletePair(entry[K, V]{}, removeMin)
+	return e.key, e.value, ok
+}
+
+// DeleteMax removes the largest key in the tree and returns it with its
+// value.  ok is false if the tree is empty.
+func (t *TreeG[K, V]) DeleteMax() (key K, value V, ok bool) {
+	e, ok := t.deletePair(entry[K, V]{}, removeMax)
+	return e.key, e.value, ok
+}
+
+// Min returns the smallest key in the tree and its value.  ok is false if
+// the tree is empty.
+func (t *TreeG[K, V]) Min() (key K, value V, ok bool) {
+	e, ok := min(t.root)
+	return e.key, e.value, ok
+}
+
+// Max returns the largest key in the tree and its value.  ok is false if the
+// tree is empty.
+func (t *TreeG[K, V]) Max() (key K, value V, ok bool) {
+	e, ok := max(t.root)
+	return e.key, e.value, ok
+}
+
+// GetAt returns the key and value at index i in the sorted order of the
+// tree.  ok is false if i is out of range.
+func (t *TreeG[K, V]) GetAt(i int) (key K, value V, ok bool) {
+	e, ok := t.getAt(i)
+	return e.key, e.value, ok
+}
+
+// DeleteAt removes the key at index i in the sorted order of the tree,
+// returning it with its value.  ok is false if i is out of range.
+func (t *TreeG[K, V]) DeleteAt(i int) (key K, value V, ok bool) {
+	e, ok := t.deleteAt(i)
+	return e.key, e.value, ok
+}
+
+// Rank returns the number of keys in the tree that are less than key.
+func (t *TreeG[K, V]) Rank(key K) int {
+	return t.rank(entry[K, V]{key: key})
+}
+
+// CountRange returns the number of keys in the tree within the range
+// [greaterOrEqual, lessThan).
+func (t *TreeG[K, V]) CountRange(greaterOrEqual, lessThan K) int {
+	return t.countRange(t.bound(greaterOrEqual), t.bound(lessThan))
+}
+
+// Floor returns the greatest key in the tree that is less than or equal to
+// key, and its value.  ok is false if there is no such key.
+func (t *TreeG[K, V]) Floor(key K) (K, V, bool) {
+	return t.nearestKey(key, descend, true)
+}
+
+// Ceiling returns the least key in the tree that is greater than or equal to
+// key, and its value.  ok is false if there is no such key.
+func (t *TreeG[K, V]) Ceiling(key K) (K, V, bool) {
+	return t.nearestKey(key, ascend, true)
+}
+
+// Lower returns the greatest key in the tree that is strictly less than key,
+// and its value.  ok is false if there is no such key.
+func (t *TreeG[K, V]) Lower(key K) (K, V, bool) {
+	return t.nearestKey(key, descend, false)
+}
+
+// Higher returns the least key in the tree that is strictly greater than
+// key, and its value.  ok is false if there is no such key.
+func (t *TreeG[K, V]) Higher(key K) (K, V, bool) {
+	return t.nearestKey(key, ascend, false)
+}
+
+func (t *TreeG[K, V]) nearestKey(key K, dir direction, inclusive bool) (K, V, bool) {
+	e, ok := t.nearest(entry[K, V]{key: key}, dir, inclusive)
+	return e.key, e.value, ok
+}
+
+func (t *TreeG[K, V]) bound(key K) optionalItem[entry[K, V]] {
+	return optional(entry[K, V]{key: key})
+}
+
+// visit adapts a key/value iterator to the entries of the tree.
+func visit[K, V any](iterator func(key K, value V) bool) func(e entry[K, V]) bool {
+	return func(e entry[K, V]) bool {
+		return iterator(e.key, e.value)
+	}
+}
+
+// AscendRange calls the iterator for every key in the tree within the range
+// [greaterOrEqual, lessThan), until iterator returns false.
+func (t *TreeG[K, V]) AscendRange(greaterOrEqual, lessThan K, iterator func(key K, value V) bool) {
+	t.iterate(ascend, t.bound(greaterOrEqual), t.bound(lessThan), true, visit(iterator))
+}
+
+// AscendLessThan calls the iterator for every key in the tree within the
+// range [first, pivot), until iterator returns false.
+func (t *TreeG[K, V]) AscendLessThan(pivot K, iterator func(key K, value V) bool) {
+	t.iterate(ascend, empty[entry[K, V]](), t.bound(pivot), false, visit(iterator))
+}
+
+// AscendGreaterOrEqual calls the iterator for every key in the tree within
+// the range [pivot, last], until iterator returns false.
+func (t *TreeG[K, V]) AscendGreaterOrEqual(pivot K, iterator func(key K, value V) bool) {
+	t.iterate(ascend, t.bound(pivot), empty[entry[K, V]](), true, visit(iterator))
+}
+
+// Ascend calls the iterator for every key in the tree within the range
+// [first, last], until iterator returns false.
+func (t *TreeG[K, V]) Ascend(iterator func(key K, value V) bool) {
+	t.iterate(ascend, empty[entry[K, V]](), empty[entry[K, V]](), false, visit(iterator))
+}
+
+// DescendRange calls the iterator for every key in the tree within the range
+// [lessOrEqual, greaterThan), until iterator returns false.
+func (t *TreeG[K, V]) DescendRange(lessOrEqual, greaterThan K, iterator func(key K, value V) bool) {
+	t.iterate(descend, t.bound(lessOrEqual), t.bound(greaterThan), true, visit(iterator))
+}
+
+// DescendLessOrEqual calls the iterator for every key in the tree within the
+// range [pivot, first], until iterator returns false.
+func (t *TreeG[K, V]) DescendLessOrEqual(pivot K, iterator func(key K, value V) bool) {
+	t.iterate(descend, t.bound(pivot), empty[entry[K, V]](), true, visit(iterator))
+}
+
+// DescendGreaterThan calls the iterator for every key in the tree within the
+// range (pivot, last], until iterator returns false.
+func (t *TreeG[K, V]) DescendGreaterThan(pivot K, iterator func(key K, value V) bool) {
+	t.iterate(descend, empty[entry[K, V]](), t.bound(pivot), false, visit(iterator))
+}
+
+// Descend calls the iterator for every key in the tree within the range
+// [last, first], until iterator returns false.
+func (t *TreeG[K, V]) Descend(iterator func(key K, value V) bool) {
+	t.iterate(descend, empty[entry[K, V]](), empty[entry[K, V]](), false, visit(iterator))
+}
+
+// CursorG represents an iterator that can traverse over all keys in a TreeG
+// in sorted order.
+//
+// Changing data while traversing a cursor may result in unexpected items to
+// be returned. You must reposition your cursor after mutating data, unless
+// the cursor was created with StableCursor.
+type CursorG[K, V any] struct {
+	c cursor[entry[K, V]]
+}
+
+// Cursor returns a new cursor used to traverse over keys in the tree.
+func (t *TreeG[K, V]) Cursor() *CursorG[K, V] {
+	return &CursorG[K, V]{cursor[entry[K, V]]{t: &t.btree}}
+}
+
+// StableCursor returns a new cursor used to traverse over keys in the tree,
+// which stays valid when the tree is modified.  See PairTree.StableCursor for
+// details.
+func (t *TreeG[K, V]) StableCursor() *CursorG[K, V] {
+	return &CursorG[K, V]{cursor[entry[K, V]]{t: &t.btree, stable: true}}
+}
+
+// CursorRange returns a new cursor used to traverse over the keys in the tree
+// between lo and hi.  Each bound includes a key equal to it when its
+// inclusive flag is set.  See PairTree.CursorRange for details.
+func (t *TreeG[K, V]) CursorRange(lo, hi K, loInclusive, hiInclusive bool) *CursorG[K, V] {
+	return &CursorG[K, V]{cursor[entry[K, V]]{
+		t:  &t.btree,
+		lo: t.bound(lo), hi: t.bound(hi),
+		loInclusive: loInclusive, hiInclusive: hiInclusive,
+	}}
+}
+
+// First moves the cursor to the first key in the tree and returns it with its
+// value.  ok is false if the tree is empty.
+func (c *CursorG[K, V]) First() (key K, value V, ok bool) {
+	e, ok := c.c.first()
+	return e.key, e.value, ok
+}
+
+// Next moves the cursor to the next key and returns it with its value.  ok
+// is false if there are no more keys.
+func (c *CursorG[K, V]) Next() (key K, value V, ok bool) {
+	e, ok := c.c.next()
+	return e.key, e.value, ok
+}
+
+// Last moves the cursor to the last key in the tree and returns it with its
+// value.  ok is false if the tree is empty.
+func (c *CursorG[K, V]) Last() (key K, value V, ok bool) {
+	e, ok := c.c.last()
+	return e.key, e.value, ok
+}
+
+// Prev moves the cursor to the previous key and returns it with its value.
+// ok is false if there are no more keys.
+func (c *CursorG[K, V]) Prev() (key K, value V, ok bool) {
+	e, ok := c.c.prev()
+	return e.key, e.value, ok
+}
+
+// Seek moves the cursor to the provided key and returns it with its value.
+// If the key does not exist then the next key is returned.
+func (c *CursorG[K, V]) Seek(pivot K) (key K, value V, ok bool) {
+	e, ok := c.c.seek(entry[K, V]{key: pivot})
+	return e.key, e.value, ok
+}
+
+// SeekGT moves the cursor to the first key greater than pivot and returns it
+// with its value.
+func (c *CursorG[K, V]) SeekGT(pivot K) (key K, value V, ok bool) {
+	e, ok := c.c.seekGT(entry[K, V]{key: pivot})
+	return e.key, e.value, ok
+}
+
+// SeekLE moves the cursor to the provided key and returns it with its value.
+// If the key does not exist then the previous key is returned.
+func (c *CursorG[K, V]) SeekLE(pivot K) (key K, value V, ok bool) {
+	e, ok := c.c.seekLE(entry[K, V]{key: pivot})
+	return e.key, e.value, ok
+}
+
+// SeekLT moves the cursor to the last key less than pivot and returns it with
+// its value.
+func (c *CursorG[K, V]) SeekLT(pivot K) (key K, value V, ok bool) {
+	e, ok := c.c.seekLT(entry[K, V]{key: pivot})
+	return e.key, e.value, ok
+}
+
+// Valid returns true if the cursor is positioned on a key.
+func (c *CursorG[K, V]) Valid() bool {
+	return c.c.ok
+}
+
+// Key returns the key under the cursor, or the zero key if the cursor is not
+// positioned on a key.
+func (c *CursorG[K, V]) Key() K {
+	return c.c.item.key
+}
+
+// Value returns the value of the key under the cursor, or the zero value if
+// the cursor is not positioned on a key.
+func (c *CursorG[K, V]) Value() V {
+	return c.c.item.value
+}
+
+// Err returns ErrModified if Next, Prev, Delete or Replace was called after
+// the tree was modified, and nil otherwise.  See Cursor.Err for details.
+func (c *CursorG[K, V]) Err() error {
+	return c.c.err
+}
+
+// Delete removes the key under the cursor from the tree, then moves the
+// cursor to the next key and returns it with its value.  If the cursor is not
+// positioned on a key, nothing is removed and ok is false.  See Cursor.Delete
+// for details.
+func (c *CursorG[K, V]) Delete() (key K, value V, ok bool) {
+	e, ok := c.c.delete()
+	return e.key, e.value, ok
+}
+
+// Replace sets the value of the key under the cursor, returning the previous
+// value.  See Cursor.Replace for details.  If the cursor is not positioned on
+// a key, nothing is replaced and ok is false.
+func (c *CursorG[K, V]) Replace(value V) (prev V, ok bool) {
+	e, ok := c.c.current()
+	if !ok {
+		return
+	}
+	e, ok = c.c.replace(entry[K, V]{e.key, value})
+	return e.value, ok
+}
@@ -0,0 +1,200 @@
+package pairtree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func intCmp(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// treeGKeys returns the keys of the tree in order, checking that each value
+// matches its key.
+func treeGKeys(t *testing.T, tr *TreeG[int, string]) (out []int) {
+	t.Helper()
+	tr.Ascend(func(key int, value string) bool {
+		if value != fmt.Sprint(key) {
+			t.Fatalf("key %d has value %q", key, value)
+		}
+		out = append(out, key)
+		return true
+	})
+	return
+}
+
+func TestTreeG(t *testing.T) {
+	for _, degree := range []int{2, 3, 9} {
+		tr := NewG[int, string](degree, intCmp)
+		for _, v := range rand.Perm(1000) {
+			if _, replaced := tr.Set(v, fmt.Sprint(v)); replaced {
+				t.Fatalf("set %d reported a replacement", v)
+			}
+		}
+		if prev, replaced := tr.Set(10, "10"); !replaced || prev != "10" {
+			t.Fatalf("expected to replace 10, got %q, %v", prev, replaced)
+		}
+		if tr.Len() != 1000 {
+			t.Fatalf("expected 1000 items, got %d", tr.Len())
+		}
+		if got := treeGKeys(t, tr); !intsEqual(got, intRange(0, 1000)) {
+			t.Fatalf("ascend mismatch: %v", got)
+		}
+		clone := tr.Clone()
+		for _, v := range rand.Perm(1000) {
+			if v%2 == 0 {
+				continue
+			}
+			if value, ok := tr.Delete(v); !ok || value != fmt.Sprint(v) {
+				t.Fatalf("delete %d: got %q, %v", v, value, ok)
+			}
+		}
+		if _, ok := tr.Delete(1); ok {
+			t.Fatal("deleted a missing key")
+		}
+		if got := treeGKeys(t, clone); !intsEqual(got, intRange(0, 1000)) {
+			t.Fatal("clone was modified")
+		}
+		if v, ok := tr.Get(500); !ok || v != "500" {
+			t.Fatalf("get 500: got %q, %v", v, ok)
+		}
+		if tr.Has(501) {
+			t.Fatal("found a deleted key")
+		}
+		if k, _, ok := tr.Floor(501); !ok || k != 500 {
+			t.Fatalf("floor 501: got %d, %v", k, ok)
+		}
+		if k, _, ok := tr.Higher(500); !ok || k != 502 {
+			t.Fatalf("higher 500: got %d, %v", k, ok)
+		}
+		if k, _, ok := tr.GetAt(3); !ok || k != 6 || tr.Rank(6) != 3 {
+			t.Fatalf("getAt 3: got %d, %v", k, ok)
+		}
+		if n := tr.CountRange(100, 200); n != 50 {
+			t.Fatalf("expected 50 keys in range, got %d", n)
+		}
+		if k, _, ok := tr.Min(); !ok || k != 0 {
+			t.Fatalf("min: got %d, %v", k, ok)
+		}
+		if k, _, ok := tr.DeleteMax(); !ok || k != 998 {
+			t.Fatalf("deleteMax: got %d, %v", k, ok)
+		}
+	}
+}
+
+func TestTreeGRanges(t *testing.T) {
+	tr := NewG[int, string](2, intCmp)
+	for _, v := range rand.Perm(100) {
+		tr.Set(v, fmt.Sprint(v))
+	}
+	var got []int
+	collect := func(key int, value string) bool {
+		got = append(got, key)
+		return true
+	}
+	tr.AscendRange(40, 60, collect)
+	if !intsEqual(got, intRange(40, 60)) {
+		t.Fatalf("ascend range mismatch: %v", got)
+	}
+	got = nil
+	tr.DescendRange(60, 40, collect)
+	if want := intRange(60, 40); !intsEqual(got, want) {
+		t.Fatalf("descend range mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	got = nil
+	tr.AscendLessThan(10, collect)
+	tr.DescendGreaterThan(95, collect)
+	if want := append(intRange(0, 10), intRange(99, 95)...); !intsEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestTreeGCursor(t *testing.T) {
+	tr := NewG[int, string](3, intCmp)
+	for _, v := range rand.Perm(200) {
+		tr.Set(v*2, fmt.Sprint(v*2))
+	}
+	c := tr.Cursor()
+	var got []int
+	for k, _, ok := c.First(); ok; k, _, ok = c.Next() {
+		got = append(got, k)
+	}
+	if len(got) != 200 || got[0] != 0 || got[199] != 398 {
+		t.Fatalf("forward mismatch: %v", got)
+	}
+	got = got[:0]
+	for k, _, ok := c.Last(); ok; k, _, ok = c.Prev() {
+		got = append(got, k)
+	}
+	if len(got) != 200 || got[0] != 398 || got[199] != 0 {
+		t.Fatalf("backward mismatch: %v", got)
+	}
+	if k, v, ok := c.Seek(51); !ok || k != 52 || v != "52" {
+		t.Fatalf("seek 51: got %d, %q, %v", k, v, ok)
+	}
+	if _, _, ok := c.Seek(399); ok {
+		t.Fatal("seek past the end found a key")
+	}
+}
+
+// intRange returns the integers from start towards end, excluding end.
+func intRange(start, end int) (out []int) {
+	for i := start; i < end; i++ {
+		out = append(out, i)
+	}
+	for i := start; i > end; i-- {
+		out = append(out, i)
+	}
+	return
+}
+
+func TestTreeGCursorDeleteReplace(t *testing.T) {
+	tr := NewG[int, string](2, intCmp)
+	for _, v := range rand.Perm(100) {
+		tr.Set(v, "")
+	}
+	c := tr.StableCursor()
+	for k, _, ok := c.First(); ok; {
+		if k%3 == 0 {
+			k, _, ok = c.Delete()
+			continue
+		}
+		c.Replace(fmt.Sprint(k))
+		k, _, ok = c.Next()
+	}
+	keys := treeGKeys(t, tr)
+	if len(keys) != 66 || keys[0] != 1 || keys[65] != 98 {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestTreeGCursorRange(t *testing.T) {
+	tr := NewG[int, string](2, intCmp)
+	for i := 0; i < 100; i++ {
+		tr.Set(i, fmt.Sprint(i))
+	}
+	c := tr.CursorRange(10, 20, false, true)
+	var got []int
+	for k, _, ok := c.Last(); ok; k, _, ok = c.Prev() {
+		got = append(got, k)
+	}
+	if !intsEqual(got, intRange(20, 10)) {
+		t.Fatalf("mismatch: %v", got)
+	}
+	if k, _, ok := c.SeekLE(50); !ok || k != 20 {
+		t.Fatalf("SeekLE(50): got %d, %v", k, ok)
+	}
+	if k, _, ok := c.SeekGT(5); !ok || k != 11 {
+		t.Fatalf("SeekGT(5): got %d, %v", k, ok)
+	}
+	if _, _, ok := c.SeekLT(11); ok {
+		t.Fatal("SeekLT(11) found a key outside of the range")
+	}
+}